@@ -0,0 +1,142 @@
+package tokenizer
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Counts of adjacent word pairs, for scoring a word by the word
+// before it.
+type bigramTable struct {
+	counts map[[2]string]int
+	// Sum of counts by first word.
+	totals map[string]int
+}
+
+// Return log P(second | first), and false if the pair is not in
+// the table.
+func (bt *bigramTable) logProba(first, second string) (float64, bool) {
+	count, found := bt.counts[[2]string{first, second}]
+	if !found || count < 1 {
+		return 0, false
+	}
+	return math.Log(float64(count)) - math.Log(float64(bt.totals[first])), true
+}
+
+// Score DAG paths with a bigram table loaded from `filename`,
+// with one "word1 word2 count" entry per line. A word that
+// follows word1 in the table scores log P(word2 | word1) on top
+// of its dictionary score. Other words get the dictionary score
+// only. If the file can't be loaded, NewTokenizerWith
+// and NewJiebaTokenizerE return the error, and the other
+// constructors panic.
+func WithBigrams(filename string) Option {
+	return func(tk *Tokenizer) {
+		bt, err := loadBigrams(filename)
+		if err != nil {
+			if tk.optErr == nil {
+				tk.optErr = err
+			}
+			return
+		}
+		tk.bigrams = bt
+	}
+}
+
+func loadBigrams(filename string) (*bigramTable, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bt := bigramTable{
+		counts: map[[2]string]int{},
+		totals: map[string]int{},
+	}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%s:%d: want \"word1 word2 count\", got %q", filename, lineNo, line)
+		}
+		count, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+		}
+		bt.counts[[2]string{parts[0], parts[1]}] += count
+		bt.totals[parts[0]] += count
+	}
+	return &bt, scanner.Err()
+}
+
+// A word ending at some rune index, and the best score of the
+// path that ends with it.
+type bigramState struct {
+	score float64
+	// Start of the word before this one, or -1 at the start of
+	// the text.
+	prevStart int
+}
+
+// Find the best path through `dag` using tk.bigrams. Unlike
+// findDagPath, a word's score depends on the word before it, so
+// the best path is tracked for every word ending at each index.
+func (tk *Tokenizer) bigramPath(textRunes []rune, dag map[int][]int) [][2]int {
+	logSize := tk.logSize()
+	// best[j][i] is the best path ending with textRunes[i:j].
+	best := make([]map[int]bigramState, len(textRunes)+1)
+	best[0] = map[int]bigramState{-1: {0, -1}}
+	for i := 0; i < len(textRunes); i++ {
+		tails := dag[i]
+		// Like findDagPath, fall back to a single rune if the
+		// DAG has no edge here.
+		if len(tails) == 0 {
+			tails = []int{i + 1}
+		}
+		for _, j := range tails {
+			word := string(textRunes[i:j])
+			unigram := tk.unigramScore(word, logSize)
+			if best[j] == nil {
+				best[j] = map[int]bigramState{}
+			}
+			for k, prev := range best[i] {
+				score := prev.score + unigram
+				if k >= 0 && !tk.blocklist[word] {
+					if p, found := tk.bigrams.logProba(string(textRunes[k:i]), word); found {
+						score += p
+					}
+				}
+				// Break ties by the longer previous word, so the
+				// result doesn't depend on map order.
+				cur, found := best[j][i]
+				if !found || score > cur.score || (score == cur.score && k < cur.prevStart) {
+					best[j][i] = bigramState{score, k}
+				}
+			}
+		}
+	}
+
+	// Pick the best last word, then walk back.
+	end := len(textRunes)
+	start := -1
+	for i, s := range best[end] {
+		if start < 0 || s.score > best[end][start].score || (s.score == best[end][start].score && i < start) {
+			start = i
+		}
+	}
+	path := [][2]int{}
+	for start >= 0 {
+		path = append([][2]int{{start, end}}, path...)
+		start, end = best[end][start].prevStart, start
+	}
+	return path
+}
@@ -0,0 +1,69 @@
+package tokenizer
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBigrams(t *testing.T) {
+	f, err := os.CreateTemp("", "bigrams*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("的 和 999\n的 和尚 1\n和 尚未 3\n\n"))
+	f.Close()
+
+	tk := Tokenizer{}
+	err = tk.buildPrefixDictionary([]string{
+		"結婚 100 v",
+		"的 500 uj",
+		"和 10 c",
+		"和尚 200 nr",
+		"尚未 10 d",
+		"未 50 d",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "結婚的和尚未結婚的"
+	want := []string{"結婚", "的", "和尚", "未", "結婚", "的"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+
+	// 和尚 rarely follows 的, which outweighs its higher
+	// frequency.
+	WithBigrams(f.Name())(&tk)
+	want = []string{"結婚", "的", "和", "尚未", "結婚", "的"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	wantPath := [][2]int{{0, 2}, {2, 3}, {3, 4}, {4, 6}, {6, 8}, {8, 9}}
+	assertDeepEqual(t, wantPath, tk.BuildLattice(text).Path)
+
+	// Without any matching pair, only the dictionary scores
+	// words.
+	assertDeepEqual(t, []string{"和尚", "未"}, tk.Cut("和尚未", false))
+}
+
+func TestLoadBigramsError(t *testing.T) {
+	f, err := os.CreateTemp("", "bigrams*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("的 和 10\n和 尚未\n"))
+	f.Close()
+
+	_, err = loadBigrams(f.Name())
+	if err == nil {
+		t.Fatal("want error for a line without count")
+	}
+}
+
+func TestWithBigramsMissingFile(t *testing.T) {
+	_, err := NewTokenizerWith(strings.NewReader("好 10 a\n"), nil, WithBigrams("no-such-bigrams.txt"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want fs.ErrNotExist, got %v", err)
+	}
+}
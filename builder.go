@@ -0,0 +1,76 @@
+package tokenizer
+
+import "fmt"
+
+// DictionaryBuilder accumulates word counts, for example from a
+// corpus, and builds a Tokenizer from them.
+type DictionaryBuilder struct {
+	counts map[string]int
+}
+
+func NewDictionaryBuilder() *DictionaryBuilder {
+	return &DictionaryBuilder{counts: map[string]int{}}
+}
+
+// Add count to the word's frequency. Counts of the same word
+// are summed.
+func (b *DictionaryBuilder) Add(word string, count int) {
+	if word == "" {
+		return
+	}
+	b.counts[word] += count
+}
+
+// Name that Source reports for a Tokenizer made by Build.
+const builderSource = "DictionaryBuilder"
+
+// Build a Tokenizer whose prefix dictionary holds the words
+// added so far. The builder can keep adding words afterwards
+// without affecting the returned Tokenizer, and Reset restores
+// the words it was built with. It's an error to build a
+// dictionary without a word, or if jieba's HMM can't be loaded.
+func (b *DictionaryBuilder) Build(opts ...Option) (*Tokenizer, error) {
+	tk := Tokenizer{}
+	for _, opt := range opts {
+		opt(&tk)
+	}
+	if tk.optErr != nil {
+		return nil, tk.optErr
+	}
+	termFreq := make(map[string]int, len(b.counts)*2)
+	size := int64(0)
+	for word, count := range b.counts {
+		termFreq[word] = count
+		size = addSize(size, count)
+
+		// Add word pieces, without overwriting real words.
+		wordR := []rune(word)
+		for i := 1; i < len(wordR); i++ {
+			piece := string(wordR[:i])
+			if _, found := termFreq[piece]; !found {
+				termFreq[piece] = 0
+			}
+		}
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("%s: %w", builderSource, ErrEmptyDictionary)
+	}
+	if err := tk.loadHMM(); err != nil {
+		return nil, err
+	}
+	tk.built = &prefixDictionary{
+		termFreq: termFreq,
+		size:     size,
+		ready:    true,
+		source:   builderSource,
+	}
+	pd := tk.built.share()
+	pd.prune(tk.minFreq)
+	tk.pd.termFreq = pd.termFreq
+	tk.pd.size = pd.size
+	tk.pd.ready = pd.ready
+	tk.pd.source = pd.source
+	tk.pd.shared = pd.shared
+	tk.ready = true
+	return &tk, nil
+}
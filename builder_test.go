@@ -0,0 +1,62 @@
+package tokenizer
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+func TestDictionaryBuilder(t *testing.T) {
+	b := NewDictionaryBuilder()
+	corpus := [][]string{
+		{"今天", "天氣", "很", "好"},
+		{"今", "天氣", "好"},
+		{"天", "很", "好"},
+	}
+	for _, words := range corpus {
+		for _, w := range words {
+			b.Add(w, 1)
+		}
+	}
+	b.Add("", 5)
+	tk, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "DictionaryBuilder", tk.Source())
+
+	want := map[string]int{
+		"今天": 1,
+		"今":  1,
+		"天氣": 2,
+		"天":  1,
+		"很":  2,
+		"好":  3,
+	}
+	assertDeepEqual(t, want, tk.pd.termFreq)
+	assertEqual(t, int64(10), tk.pd.size)
+	assertDeepEqual(t, []string{"今天", "天氣", "很", "好"}, tk.Cut("今天天氣很好", false))
+
+	// Later words don't change a built tokenizer.
+	b.Add("天天", 100)
+	_, found := tk.pd.termFreq["天天"]
+	assertEqual(t, false, found)
+
+	// Reset restores the built words.
+	tk.AddWord("天天", 100)
+	if err := tk.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, want, tk.pd.termFreq)
+	assertEqual(t, int64(10), tk.pd.size)
+
+	_, err = NewDictionaryBuilder().Build()
+	if !errors.Is(err, ErrEmptyDictionary) {
+		t.Errorf("want ErrEmptyDictionary, got %v", err)
+	}
+	_, err = b.Build(WithBigrams(filepath.Join(t.TempDir(), "bigrams.txt")))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("want fs.ErrNotExist, got %v", err)
+	}
+}
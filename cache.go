@@ -0,0 +1,90 @@
+package tokenizer
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Keep the results of the last `size` distinct Cut calls, so that
+// text that recurs, such as search queries, is cut once. A cached
+// result is returned as is, so callers must not modify it. The
+// cache is cleared when the dictionary changes, e.g. by AddWord,
+// but not when exported fields such as KeepURLs are changed; set
+// those before cutting. Sizes below 1 disable the cache.
+func WithCache(size int) Option {
+	return func(tk *Tokenizer) {
+		if size < 1 {
+			tk.cache = nil
+			return
+		}
+		tk.cache = &cutCache{
+			size:    size,
+			entries: map[cutKey]*list.Element{},
+			order:   list.New(),
+		}
+	}
+}
+
+type cutKey struct {
+	text   string
+	useHmm bool
+}
+
+type cutEntry struct {
+	key    cutKey
+	tokens []string
+}
+
+// Least recently used cache of Cut results.
+type cutCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[cutKey]*list.Element
+	// Most recently used first.
+	order *list.List
+}
+
+func (c *cutCache) get(key cutKey) ([]string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cutEntry).tokens, true
+}
+
+func (c *cutCache) put(key cutKey, tokens []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, found := c.entries[key]; found {
+		elem.Value.(*cutEntry).tokens = tokens
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cutEntry{key, tokens})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cutEntry).key)
+	}
+}
+
+func (c *cutCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = map[cutKey]*list.Element{}
+	c.order.Init()
+}
+
+// Drop cached Cut results after the dictionary has changed.
+// Callers must hold the write lock of tk.pd, the same one as the
+// change: Cuts store their results under the read lock, so none
+// from the old dictionary can be stored, or read, between the
+// change and the clear.
+func (tk *Tokenizer) clearCache() {
+	if tk.cache != nil {
+		tk.cache.clear()
+	}
+}
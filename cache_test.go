@@ -0,0 +1,41 @@
+package tokenizer
+
+import "testing"
+
+func TestWithCache(t *testing.T) {
+	tk := Tokenizer{}
+	WithCache(2)(&tk)
+	err := tk.buildPrefixDictionary([]string{
+		"上海 50 ns",
+		"交通 40 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "上海交通大學"
+	first := tk.Cut(text, false)
+	assertDeepEqual(t, []string{"上海", "交通", "大", "學"}, first)
+	if again := tk.Cut(text, false); &again[0] != &first[0] {
+		t.Error("want the cached slice")
+	}
+	if other := tk.Cut(text, true); len(other) != 0 && &other[0] == &first[0] {
+		t.Error("want a separate entry for HMM")
+	}
+
+	// 上海交通大學 was used less recently than 好 and 上海.
+	tk.Cut("好", false)
+	tk.Cut("上海", false)
+	if again := tk.Cut(text, false); &again[0] == &first[0] {
+		t.Error("want the least recently used entry evicted")
+	}
+
+	tk.Cut(text, false)
+	tk.AddWords(map[string]int{"大學": 30})
+	assertDeepEqual(t, []string{"上海", "交通", "大學"}, tk.Cut(text, false))
+	tk.AddWord("大", 10)
+	assertEqual(t, 0, tk.cache.order.Len())
+	tk.Cut(text, false)
+	tk.TuneFreq("好", 1)
+	assertEqual(t, 0, tk.cache.order.Len())
+}
@@ -0,0 +1,74 @@
+package tokenizer
+
+import "unicode/utf8"
+
+// SegDiff compares a segmentation with a reference segmentation
+// of the same text. Spans are [start, end) rune indexes into the
+// text.
+type SegDiff struct {
+	// Share of the segmentation's tokens that are in the
+	// reference, share of the reference's tokens that were
+	// found, and their harmonic mean.
+	Precision float64
+	Recall    float64
+	F1        float64
+	// Reference tokens that the segmentation cut differently.
+	Missing [][2]int
+	// Tokens of the segmentation that aren't in the reference.
+	Extra [][2]int
+}
+
+// Score the segmentation `got` against the reference `want` by
+// token spans, the usual measure for Chinese word segmentation.
+// A token counts as correct only if a reference token starts and
+// ends at the same runes. An empty segmentation has nothing
+// wrong, so its precision, or recall for an empty reference, is
+// 1.
+func CompareSegmentations(want, got []string) SegDiff {
+	wantSpans := tokenSpans(want)
+	gotSpans := tokenSpans(got)
+	inGot := map[[2]int]bool{}
+	for _, span := range gotSpans {
+		inGot[span] = true
+	}
+	diff := SegDiff{}
+	correct := 0
+	inWant := map[[2]int]bool{}
+	for _, span := range wantSpans {
+		inWant[span] = true
+		if inGot[span] {
+			correct++
+		} else {
+			diff.Missing = append(diff.Missing, span)
+		}
+	}
+	for _, span := range gotSpans {
+		if !inWant[span] {
+			diff.Extra = append(diff.Extra, span)
+		}
+	}
+	diff.Precision = ratio(correct, len(gotSpans))
+	diff.Recall = ratio(correct, len(wantSpans))
+	if diff.Precision+diff.Recall > 0 {
+		diff.F1 = 2 * diff.Precision * diff.Recall / (diff.Precision + diff.Recall)
+	}
+	return diff
+}
+
+func tokenSpans(tokens []string) [][2]int {
+	spans := make([][2]int, 0, len(tokens))
+	start := 0
+	for _, token := range tokens {
+		end := start + utf8.RuneCountInString(token)
+		spans = append(spans, [2]int{start, end})
+		start = end
+	}
+	return spans
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 1
+	}
+	return float64(n) / float64(total)
+}
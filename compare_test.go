@@ -0,0 +1,29 @@
+package tokenizer
+
+import "testing"
+
+func TestCompareSegmentations(t *testing.T) {
+	want := []string{"研究", "生命", "起源"}
+	got := []string{"研究生", "命", "起源"}
+	diff := CompareSegmentations(want, got)
+	// Only 起源 matches: 1 of 3 either way.
+	assertEqual(t, 1.0/3, diff.Precision)
+	assertEqual(t, 1.0/3, diff.Recall)
+	assertEqual(t, 1.0/3, diff.F1)
+	assertDeepEqual(t, [][2]int{{0, 2}, {2, 4}}, diff.Missing)
+	assertDeepEqual(t, [][2]int{{0, 3}, {3, 4}}, diff.Extra)
+
+	// Over-segmented: only 好 matches, 1 of 3 tokens and 1 of 2
+	// reference tokens.
+	diff = CompareSegmentations([]string{"上海", "好"}, []string{"上", "海", "好"})
+	assertEqual(t, 1.0/3, diff.Precision)
+	assertEqual(t, 0.5, diff.Recall)
+	assertEqual(t, 0.4, diff.F1)
+
+	diff = CompareSegmentations(want, want)
+	assertDeepEqual(t, SegDiff{Precision: 1, Recall: 1, F1: 1}, diff)
+	diff = CompareSegmentations(want, nil)
+	assertEqual(t, 1.0, diff.Precision)
+	assertEqual(t, 0.0, diff.Recall)
+	assertEqual(t, 0.0, diff.F1)
+}
@@ -0,0 +1,136 @@
+package tokenizer
+
+// CutOption changes how one call to CutOpts cuts text, without
+// changing the Tokenizer, so that calls sharing a Tokenizer
+// across goroutines can each cut their own way. CutOptions are
+// named CutWith..., apart from the Options of the constructors.
+type CutOption func(*cutOptions)
+
+type cutOptions struct {
+	useHmm bool
+	// Copy of the Tokenizer whose fields the options change.
+	tk *Tokenizer
+}
+
+// Cut with HMM or without it. CutOpts uses HMM by default.
+func CutWithHMM(on bool) CutOption {
+	return func(o *cutOptions) {
+		o.useHmm = on
+	}
+}
+
+// Override Tokenizer.HMMMinLen.
+func CutWithHMMMinLen(n int) CutOption {
+	return func(o *cutOptions) {
+		o.tk.HMMMinLen = n
+	}
+}
+
+// Override Tokenizer.FoldCase.
+func CutWithFoldCase(on bool) CutOption {
+	return func(o *cutOptions) {
+		o.tk.FoldCase = on
+	}
+}
+
+// Override Tokenizer.KeepURLs.
+func CutWithKeepURLs(on bool) CutOption {
+	return func(o *cutOptions) {
+		o.tk.KeepURLs = on
+	}
+}
+
+// Override Tokenizer.KeepNumbers.
+func CutWithKeepNumbers(on bool) CutOption {
+	return func(o *cutOptions) {
+		o.tk.KeepNumbers = on
+	}
+}
+
+// Override Tokenizer.Newlines.
+func CutWithNewlines(mode NewlineMode) CutOption {
+	return func(o *cutOptions) {
+		o.tk.Newlines = mode
+	}
+}
+
+// Like Cut, but with options for this call only, on top of the
+// Tokenizer's fields. With no options, it's Cut(text, true).
+// Results are never cached, since they depend on the options.
+func (tk *Tokenizer) CutOpts(text string, opts ...CutOption) []string {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	o := cutOptions{useHmm: true, tk: tk.copyForCall()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// Share tk's index of folded terms rather than build one for
+	// each call.
+	if o.tk.FoldCase {
+		o.tk.pd.folded = tk.pd.foldedKeys()
+		o.tk.pd.foldOnce.Do(func() {})
+	}
+	return o.tk.cut(text, o.useHmm)
+}
+
+// Return a copy of tk that shares its dictionary, HMM and tables,
+// for cutting with different fields. The copy's prefix dictionary
+// has a lock of its own, which is never taken: callers must hold
+// tk.pd.lock while they use the copy, and must not change the
+// dictionary through it. Struct assignment would copy the lock,
+// so every field is listed; TestCopyForCall fails if one is
+// missed.
+func (tk *Tokenizer) copyForCall() *Tokenizer {
+	c := &Tokenizer{
+		KeepURLs:        tk.KeepURLs,
+		Units:           tk.Units,
+		MergeParticles:  tk.MergeParticles,
+		KeepNumbers:     tk.KeepNumbers,
+		MergeNumerals:   tk.MergeNumerals,
+		FoldCase:        tk.FoldCase,
+		CollapseRepeats: tk.CollapseRepeats,
+		KeepPunctuation: tk.KeepPunctuation,
+		Pinyin:          tk.Pinyin,
+		SplitAlphaNum:   tk.SplitAlphaNum,
+		Newlines:        tk.Newlines,
+		KeepDiacritics:  tk.KeepDiacritics,
+		GroupUnknown:    tk.GroupUnknown,
+		GroupCategories: tk.GroupCategories,
+		MarkUnknownHan:  tk.MarkUnknownHan,
+		MixedWords:      tk.MixedWords,
+		HMMMinLen:       tk.HMMMinLen,
+		MaxWordLen:      tk.MaxWordLen,
+		MaxTokenLen:     tk.MaxTokenLen,
+		DefaultPOS:      tk.DefaultPOS,
+		EdgeScorer:      tk.EdgeScorer,
+		HanRunes:        tk.HanRunes,
+
+		ready:          tk.ready,
+		hmm:            tk.hmm,
+		hmmErr:         tk.hmmErr,
+		optErr:         tk.optErr,
+		idf:            tk.idf,
+		defaultIDF:     tk.defaultIDF,
+		lengthBonus:    tk.lengthBonus,
+		minFreq:        tk.minFreq,
+		freqOverflow:   tk.freqOverflow,
+		runeMap:        tk.runeMap,
+		hmmRuneMap:     tk.hmmRuneMap,
+		forced:         tk.forced,
+		bigrams:        tk.bigrams,
+		layers:         tk.layers,
+		blocklist:      tk.blocklist,
+		keepChars:      tk.keepChars,
+		userDictWeight: tk.userDictWeight,
+		delimiters:     tk.delimiters,
+		built:          tk.built,
+		cutBlockHook:   tk.cutBlockHook,
+	}
+	c.pd.termFreq = tk.pd.termFreq
+	c.pd.size = tk.pd.size
+	c.pd.ready = tk.pd.ready
+	c.pd.source = tk.pd.source
+	c.pd.shared = true
+	c.pd.pos = tk.pd.pos
+	return c
+}
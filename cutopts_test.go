@@ -0,0 +1,187 @@
+package tokenizer
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"unicode"
+)
+
+func TestCutOpts(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"卡拉OK 30 n",
+		"今天 100 t",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.MixedWords = true
+	tk.hmm = newTestHMM(map[string]map[string]float64{
+		"B": {"他": -0.1},
+		"E": {"们": -0.1},
+	})
+	text := "卡拉ok他们今天好\n3.5"
+	cases := []struct {
+		opts []CutOption
+		want []string
+	}{
+		{nil, []string{"卡", "拉", "ok", "他们", "今天", "好", "3", ".", "5"}},
+		{[]CutOption{CutWithFoldCase(true)}, []string{"卡拉ok", "他们", "今天", "好", "3", ".", "5"}},
+		{
+			[]CutOption{CutWithKeepNumbers(true), CutWithNewlines(NewlineKeep)},
+			[]string{"卡", "拉", "ok", "他们", "今天", "好", "\n", "3.5"},
+		},
+		{
+			[]CutOption{CutWithHMM(false), CutWithFoldCase(true), CutWithKeepNumbers(true)},
+			[]string{"卡拉ok", "他", "们", "今天", "好", "3.5"},
+		},
+	}
+	// The calls share tk, and each must see only its own options.
+	wg := sync.WaitGroup{}
+	for _, c := range cases {
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(opts []CutOption, want []string) {
+				defer wg.Done()
+				assertDeepEqual(t, want, tk.CutOpts(text, opts...))
+			}(c.opts, c.want)
+		}
+	}
+	wg.Wait()
+	assertEqual(t, false, tk.FoldCase)
+	assertEqual(t, false, tk.KeepNumbers)
+	assertDeepEqual(t, tk.Cut(text, true), tk.CutOpts(text))
+}
+
+func TestCopyForCall(t *testing.T) {
+	// Every field is set, so that a field copyForCall misses
+	// shows up as a zero value in the copy.
+	tk := Tokenizer{
+		KeepURLs:        true,
+		Units:           DefaultUnits,
+		MergeParticles:  map[string]bool{"的": true},
+		KeepNumbers:     true,
+		MergeNumerals:   true,
+		FoldCase:        true,
+		CollapseRepeats: true,
+		KeepPunctuation: true,
+		Pinyin:          true,
+		SplitAlphaNum:   true,
+		Newlines:        NewlineKeep,
+		KeepDiacritics:  true,
+		GroupUnknown:    true,
+		GroupCategories: []*unicode.RangeTable{unicode.Hangul},
+		MarkUnknownHan:  true,
+		MixedWords:      true,
+		HMMMinLen:       2,
+		MaxWordLen:      3,
+		MaxTokenLen:     4,
+		DefaultPOS:      "x",
+		EdgeScorer:      func(word string, freq int, size int64) float64 { return 0 },
+		HanRunes:        func(r rune) bool { return false },
+
+		ready:          true,
+		hmm:            newTestHMM(nil),
+		hmmErr:         errors.New("no HMM"),
+		optErr:         errors.New("bad option"),
+		idf:            map[string]float64{"好": 1},
+		defaultIDF:     1,
+		lengthBonus:    1,
+		minFreq:        1,
+		freqOverflow:   FreqOverflowClamp,
+		runeMap:        map[rune]rune{'這': '这'},
+		hmmRuneMap:     map[rune]rune{'這': '这'},
+		forced:         &forcedSegments{},
+		bigrams:        &bigramTable{},
+		layers:         []*prefixDictionary{{}},
+		cache:          &cutCache{},
+		blocklist:      map[string]bool{"好": true},
+		keepChars:      map[rune]bool{'/': true},
+		userDictWeight: 1,
+		delimiters:     map[rune]bool{'。': true},
+		built:          &prefixDictionary{},
+		cutBlockHook:   func(textBlock) {},
+	}
+	if err := tk.buildPrefixDictionary([]string{"好 90 a"}); err != nil {
+		t.Fatal(err)
+	}
+	tk.pd.ready = true
+	tk.pd.source = "dict.txt"
+	tk.pd.pos = map[string]string{"好": "a"}
+	c := tk.copyForCall()
+
+	orig := reflect.ValueOf(&tk).Elem()
+	copied := reflect.ValueOf(c).Elem()
+	for i := 0; i < orig.NumField(); i++ {
+		name := orig.Type().Field(i).Name
+		switch name {
+		case "pd":
+			// Checked below.
+			continue
+		case "cache":
+			// CutOpts results are never cached.
+			if !copied.Field(i).IsNil() {
+				t.Error("want no cache in the copy")
+			}
+			continue
+		}
+		if orig.Field(i).IsZero() {
+			t.Errorf("set %s in this test", name)
+			continue
+		}
+		if !sameValue(orig.Field(i), copied.Field(i)) {
+			t.Errorf("%s isn't copied", name)
+		}
+	}
+
+	pdOrig := orig.FieldByName("pd")
+	pdCopied := copied.FieldByName("pd")
+	for i := 0; i < pdOrig.NumField(); i++ {
+		name := pdOrig.Type().Field(i).Name
+		switch name {
+		case "lock", "folded", "foldOnce", "shared", "overflow":
+			// The copy has a lock and folded terms of its own,
+			// and always shares termFreq. overflow only matters
+			// when a file is loaded.
+			continue
+		}
+		if pdOrig.Field(i).IsZero() {
+			t.Errorf("set pd.%s in this test", name)
+			continue
+		}
+		if !sameValue(pdOrig.Field(i), pdCopied.Field(i)) {
+			t.Errorf("pd.%s isn't copied", name)
+		}
+	}
+}
+
+// Report whether a and b hold the same value, or refer to the
+// same map, slice, function or pointer. Unlike DeepEqual, it
+// works on unexported fields.
+func sameValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func, reflect.Ptr:
+		return a.Pointer() == b.Pointer()
+	case reflect.Interface:
+		return a.IsNil() == b.IsNil() && (a.IsNil() || sameValue(a.Elem(), b.Elem()))
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.String:
+		return a.String() == b.String()
+	}
+	panic("sameValue: unhandled kind " + a.Kind().String())
+}
@@ -0,0 +1,24 @@
+package tokenizer
+
+import "sync"
+
+var (
+	defaultTokenizer *Tokenizer
+	defaultOnce      sync.Once
+)
+
+// Return the package's default tokenizer, which uses jieba's
+// dictionary. It's loaded on first use and shared by every
+// caller, so changes to it, such as AddWord, are global.
+func Default() *Tokenizer {
+	defaultOnce.Do(func() {
+		defaultTokenizer = NewJiebaTokenizer()
+	})
+	return defaultTokenizer
+}
+
+// Cut text with the default tokenizer. It's safe for concurrent
+// use. See Default.
+func Cut(text string, useHmm bool) []string {
+	return Default().Cut(text, useHmm)
+}
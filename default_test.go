@@ -0,0 +1,155 @@
+package tokenizer
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	done := make(chan *Tokenizer)
+	for i := 0; i < 4; i++ {
+		go func() {
+			done <- Default()
+		}()
+	}
+	tk := <-done
+	for i := 1; i < 4; i++ {
+		if <-done != tk {
+			t.Fatal("want one default tokenizer")
+		}
+	}
+	assertEqual(t, true, tk.Ready())
+	assertEqual(t, "prefix_dictionary.gob", tk.Source())
+	text := "我昨天去上海交通大學與老師討論量子力學"
+	assertDeepEqual(t, tk.Cut(text, true), Cut(text, true))
+}
+
+func TestNewJiebaTokenizerE(t *testing.T) {
+	tk, err := NewJiebaTokenizerE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, true, tk.Ready())
+	assertEqual(t, "prefix_dictionary.gob", tk.Source())
+
+	filename := filepath.Join(t.TempDir(), jiebaGobFile)
+	_, err = loadJiebaPrefixDictionary(filename)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("want fs.ErrNotExist, got %v", err)
+	}
+
+	os.WriteFile(filename, []byte("not a gob"), 0o644)
+	_, err = loadJiebaPrefixDictionary(filename)
+	if !errors.Is(err, ErrDecode) {
+		t.Errorf("want ErrDecode, got %v", err)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gob.NewEncoder(f).Encode(map[string]int{})
+	f.Close()
+	_, err = loadJiebaPrefixDictionary(filename)
+	if !errors.Is(err, ErrEmptyDictionary) {
+		t.Errorf("want ErrEmptyDictionary, got %v", err)
+	}
+}
+
+func TestWithoutHMM(t *testing.T) {
+	_, err := loadEmitProba(filepath.Join(t.TempDir(), jiebaEmitJSONFile))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want fs.ErrNotExist, got %v", err)
+	}
+
+	tk := Tokenizer{}
+	err = tk.buildPrefixDictionary([]string{"今天 100 t", "天氣 30 n", "很 80 d", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, false, tk.HasHMM())
+	if err := tk.setHMM(hiddenMarkovModel{}, err); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, false, tk.HasHMM())
+
+	// Cutting with HMM falls back to cutting without it, on
+	// every path.
+	text := "今天天氣很好，撙節開支"
+	want := []string{"今天", "天氣", "很", "好", "撙", "節", "開", "支"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	assertDeepEqual(t, want, tk.Cut(text, true))
+	assertDeepEqual(t, want, tk.CutParallel(text, true, 2, true))
+	assertDeepEqual(t, want, tk.CutRunes([]rune(text), true))
+	assertDeepEqual(t, want, tk.CutOpts(text))
+	assertDeepEqual(t, []string{"撙", "節"}, tk.CutHMMOnly("撙節"))
+	assertDeepEqual(t, []Keyword{{"今天", 0.5}, {"天氣", 0.5}}, tk.ExtractTags("今天天氣很好", 0))
+}
+
+func TestNewTokenizerWith(t *testing.T) {
+	dict := strings.NewReader("今天 100 t\n天氣 30 n\n好 90 a\n")
+	hmm, err := NewHMM(
+		map[string]float64{"B": -0.5, "E": minFloat, "M": minFloat, "S": -1.0},
+		map[string]map[string]float64{
+			"B": {"E": -0.5, "M": -1.0},
+			"E": {"B": -0.5, "S": -1.0},
+			"M": {"E": -0.5, "M": -1.0},
+			"S": {"B": -0.5, "S": -1.0},
+		},
+		map[string]map[string]float64{
+			"B": {"很": -1.0},
+			"E": {"棒": -1.0},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk, err := NewTokenizerWith(dict, hmm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, true, tk.Ready())
+	text := "今天天氣很棒"
+	assertDeepEqual(t, []string{"今天", "天氣", "很", "棒"}, tk.Cut(text, false))
+	assertDeepEqual(t, []string{"今天", "天氣", "很棒"}, tk.Cut(text, true))
+	assertEqual(t, "NewTokenizerWith", tk.Source())
+
+	// Reset restores the dictionary read from r.
+	want := map[string]int{"今": 0, "今天": 100, "天": 0, "天氣": 30, "好": 90}
+	assertDeepEqual(t, want, tk.pd.termFreq)
+	tk.AddWord("很棒", 100)
+	if err := tk.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, want, tk.pd.termFreq)
+	assertEqual(t, int64(220), tk.pd.size)
+
+	tk, err = NewTokenizerWith(strings.NewReader("好 90 a\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, false, tk.HasHMM())
+
+	_, err = NewTokenizerWith(strings.NewReader("好 x\n"), hmm)
+	assertEqual(t, `dictionary:1: invalid frequency "x"`, fmt.Sprint(err))
+	_, err = NewTokenizerWith(strings.NewReader("好 1\n好的 99999999999999999999\n"), hmm)
+	if !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("want strconv.ErrRange, got %v", err)
+	}
+	assertEqual(t, `dictionary:2: invalid frequency "99999999999999999999": value out of range`, fmt.Sprint(err))
+	_, err = NewTokenizerWith(strings.NewReader(""), hmm)
+	if !errors.Is(err, ErrEmptyDictionary) {
+		t.Errorf("want ErrEmptyDictionary, got %v", err)
+	}
+	_, err = NewHMM(map[string]float64{"B": 0}, nil, nil)
+	if err == nil {
+		t.Error("want an error for missing states")
+	}
+}
@@ -0,0 +1,162 @@
+package tokenizer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Keyword is a word and its TF-IDF weight.
+type Keyword struct {
+	Word   string
+	Weight float64
+}
+
+// English stop words skipped by keyword extraction. This is the
+// same list as jieba.analyse.
+var stopWords = map[string]bool{
+	"the": true, "of": true, "is": true, "and": true, "to": true,
+	"in": true, "that": true, "we": true, "for": true, "an": true,
+	"are": true, "by": true, "be": true, "as": true, "on": true,
+	"with": true, "can": true, "if": true, "from": true, "which": true,
+	"you": true, "it": true, "this": true, "then": true, "at": true,
+	"have": true, "all": true, "not": true, "one": true, "has": true,
+	"or": true,
+}
+
+// Extract the topK keywords of text, ranked by TF-IDF. Tokens
+// shorter than 2 runes and stop words are skipped. If topK is less
+// than 1, all keywords are returned. Text is cut with HMM, if the
+// tokenizer has one.
+func (tk *Tokenizer) ExtractTags(text string, topK int) []Keyword {
+	counts := map[string]int{}
+	for word, count := range tk.WordCounts(text, tk.HasHMM()) {
+		if isKeyword(word) {
+			counts[word] += count
+		}
+	}
+	return tk.rankKeywords(counts, topK)
+}
+
+// Extract the topK keywords of the text read from r. Unlike
+// ExtractTags, the text is cut as a stream and only the running
+// count of each word is kept in memory.
+func (tk *Tokenizer) ExtractTagsReader(r io.Reader, topK int) ([]Keyword, error) {
+	counts := map[string]int{}
+	err := tk.CutReader(r, tk.HasHMM(), func(token string) bool {
+		if isKeyword(token) {
+			counts[token]++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tk.rankKeywords(counts, topK), nil
+}
+
+func isKeyword(word string) bool {
+	word = strings.TrimSpace(word)
+	return utf8.RuneCountInString(word) >= 2 && !stopWords[strings.ToLower(word)]
+}
+
+// Weigh each word by TF-IDF, and return the topK words with the
+// highest weights. Ties are broken by word order.
+func (tk *Tokenizer) rankKeywords(counts map[string]int, topK int) []Keyword {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	keywords := make([]Keyword, 0, len(counts))
+	for word, count := range counts {
+		tf := float64(count) / float64(total)
+		keywords = append(keywords, Keyword{word, tf * tk.idfOf(word)})
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if keywords[i].Weight != keywords[j].Weight {
+			return keywords[i].Weight > keywords[j].Weight
+		}
+		return keywords[i].Word < keywords[j].Word
+	})
+	if topK > 0 && topK < len(keywords) {
+		keywords = keywords[:topK]
+	}
+	return keywords
+}
+
+// Return the IDF of a word. Without an IDF table, every word has
+// an IDF of 1 and keywords are ranked by term frequency alone.
+func (tk *Tokenizer) idfOf(word string) float64 {
+	if tk.idf == nil {
+		return 1.0
+	}
+	if idf, found := tk.idf[word]; found {
+		return idf
+	}
+	return tk.defaultIDF
+}
+
+// Use an IDF table for keyword extraction. Words that are not in
+// the table get defaultIDF. If defaultIDF is less than or equal
+// to 0, the median IDF of the table is used, like jieba does.
+//
+// Words missing from the table are usually rare, so the default
+// decides how they rank: a higher default favors unknown words,
+// such as names and jargon, over common words that are in the
+// table; a lower default does the opposite.
+func WithIDF(idf map[string]float64, defaultIDF float64) Option {
+	return func(tk *Tokenizer) {
+		if defaultIDF <= 0 {
+			defaultIDF = medianIDF(idf)
+		}
+		tk.idf = idf
+		tk.defaultIDF = defaultIDF
+	}
+}
+
+func medianIDF(idf map[string]float64) float64 {
+	if len(idf) == 0 {
+		return 1.0
+	}
+	values := make([]float64, 0, len(idf))
+	for _, v := range idf {
+		values = append(values, v)
+	}
+	sort.Float64s(values)
+	// jieba takes the upper middle value of an even-sized table.
+	return values[len(values)/2]
+}
+
+// Load an IDF table in jieba's idf.txt format, with one
+// "word idf" entry per line.
+func LoadIDF(filename string) (map[string]float64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	idf := map[string]float64{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"word idf\", got %q", filename, lineNo, line)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+		}
+		idf[parts[0]] = value
+	}
+	return idf, scanner.Err()
+}
@@ -0,0 +1,111 @@
+package tokenizer
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExtractTags(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+		"北京 50 ns",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "今天北京天氣很好。今天北京很好！the weather in 北京 is good"
+	want := []Keyword{
+		{"北京", 3.0 / 8},
+		{"今天", 2.0 / 8},
+		{"good", 1.0 / 8},
+	}
+	got := tk.ExtractTags(text, 3)
+	assertDeepEqual(t, want, got)
+}
+
+func TestExtractTagsReader(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+		"北京 50 ns",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("今天北京天氣很好。今天北京很好！the weather in 北京 is good\n", 100)
+	want := tk.ExtractTags(text, 0)
+	got, err := tk.ExtractTagsReader(strings.NewReader(text), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, want, got)
+}
+
+func TestExtractTagsWithIDF(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"北京 50 ns",
+		"天氣 30 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idf := map[string]float64{
+		"今天": 1.0,
+		"北京": 4.0,
+		"天氣": 2.0,
+	}
+	text := "今天今天今天北京天氣 weather"
+	tf := 1.0 / 6
+
+	// "weather" is not in the table, and gets the median, 2.0.
+	WithIDF(idf, 0)(&tk)
+	want := []Keyword{
+		{"北京", tf * 4.0},
+		{"今天", 3 * tf * 1.0},
+		{"weather", tf * 2.0},
+		{"天氣", tf * 2.0},
+	}
+	assertDeepEqual(t, want, tk.ExtractTags(text, 0))
+
+	// A high default IDF favors words not in the table.
+	WithIDF(idf, 10.0)(&tk)
+	got := tk.ExtractTags(text, 1)
+	assertDeepEqual(t, []Keyword{{"weather", tf * 10.0}}, got)
+}
+
+func TestLoadIDF(t *testing.T) {
+	f, err := os.CreateTemp("", "idf*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("北京 4.5\n天氣 2.25\n\n"))
+	f.Close()
+
+	got, err := LoadIDF(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"北京": 4.5, "天氣": 2.25}
+	assertDeepEqual(t, want, got)
+	assertEqual(t, 4.5, medianIDF(got))
+
+	os.WriteFile(f.Name(), []byte("北京\n"), 0644)
+	if _, err := LoadIDF(f.Name()); err == nil {
+		t.Error("want error for a line without idf")
+	}
+}
@@ -0,0 +1,220 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Edge is a candidate word in a Lattice. It spans the runes
+// [Start, End) of the lattice's text.
+type Edge struct {
+	Start int
+	End   int
+	// Log probability of the best route from Start to the end of
+	// the text that begins with this edge. Bigrams, if any, are
+	// not included.
+	Proba float64
+}
+
+// Lattice is the segmentation graph of a piece of text: every
+// candidate word found in the prefix dictionary, and the path
+// that Cut would choose through them. All indexes are based on
+// []rune(Text).
+type Lattice struct {
+	Text string
+	// Edges[i] holds the candidate words that start at rune i.
+	Edges [][]Edge
+	// Best path from rune 0 to the end of Text.
+	Path [][2]int
+}
+
+// Build the segmentation lattice of `text` without HMM.
+// The tokenizer is only read, never modified, so BuildLattice
+// is safe to call concurrently with Cut and other readers.
+func (tk *Tokenizer) BuildLattice(text string) Lattice {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	return tk.buildLattice([]rune(text))
+}
+
+// Return the dictionary words that start at rune `pos` of text,
+// shortest first, with their frequencies. These are the edges
+// from pos in the lattice, without building the rest of it. Out
+// of range positions have no candidates.
+func (tk *Tokenizer) CandidatesAt(text string, pos int) []WordFreq {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	textRunes := []rune(text)
+	if pos < 0 || pos >= len(textRunes) {
+		return nil
+	}
+	ws := dagWorkspaces.Get().(*dagWorkspace)
+	defer dagWorkspaces.Put(ws)
+	tk.dagTails(tk.mapRunes(textRunes), pos, tk.maxWordLen(), ws)
+	candidates := []WordFreq{}
+	for k, j := range ws.tails {
+		if ws.freqs[k] > 0 {
+			candidates = append(candidates, WordFreq{string(textRunes[pos:j]), ws.freqs[k]})
+		}
+	}
+	return candidates
+}
+
+// Return every dictionary word with a frequency above 0 that
+// occurs in the Han blocks of text, whether or not Cut would
+// choose it, ordered by Start and then by End. Each span is
+// returned once. This is the set of edges of the DAG, and is
+// suited to matching a gazetteer against a document.
+func (tk *Tokenizer) FindWords(text string) []Token {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	runes := []rune(text)
+	mapped := tk.mapRunes(runes)
+	ws := dagWorkspaces.Get().(*dagWorkspace)
+	defer dagWorkspaces.Put(ws)
+	words := []Token{}
+	for _, block := range tk.splitBlocks(runes) {
+		if !block.doProcess {
+			continue
+		}
+		blockRunes := mapped[block.start:block.end]
+		for i := range blockRunes {
+			tk.dagTails(blockRunes, i, tk.maxWordLen(), ws)
+			for k, j := range ws.tails {
+				if ws.freqs[k] < 1 {
+					continue
+				}
+				start, end := block.start+i, block.start+j
+				word := string(runes[start:end])
+				words = append(words, Token{Word: word, Start: start, End: end, POS: tk.wordPOS(word)})
+			}
+		}
+	}
+	return words
+}
+
+func (tk *Tokenizer) buildLattice(textRunes []rune) Lattice {
+	dag := tk.buildDag(textRunes)
+	dagProba := tk.calcDagProba(textRunes, dag)
+
+	edges := make([][]Edge, len(textRunes))
+	for i := range edges {
+		for _, tail := range dagProba[i] {
+			edges[i] = append(edges[i], Edge{i, tail.index, tail.proba})
+		}
+	}
+	path := findDagPath(textRunes, dagProba)
+	if tk.bigrams != nil {
+		path = tk.bigramPath(textRunes, dag)
+	}
+	return Lattice{
+		Text:  string(textRunes),
+		Edges: edges,
+		Path:  path,
+	}
+}
+
+// Return the log probability of a segmentation under the
+// dictionary model: the sum of each token's edge score, as
+// used to pick the best path in Cut. Tokens that are not words
+// get a frequency of 1.
+func (tk *Tokenizer) ScoreSegmentation(tokens []string) float64 {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	logSize := tk.logSize()
+	score := 0.0
+	for _, token := range tokens {
+		score += tk.unigramScore(token, logSize)
+	}
+	return score
+}
+
+// Return the segmentation lattice of `text` as a Graphviz DOT
+// graph. Nodes are the rune positions, edges are the candidate
+// words labeled with their log probabilities, and the edges of
+// the best path are drawn in red. Like BuildLattice, it's
+// read-only, and HMM is not used.
+func (tk *Tokenizer) DAGToDOT(text string) string {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	textRunes := []rune(text)
+	lat := tk.buildLattice(textRunes)
+	onPath := map[[2]int]bool{}
+	for _, p := range lat.Path {
+		onPath[p] = true
+	}
+	logSize := tk.logSize()
+
+	dot := strings.Builder{}
+	fmt.Fprintln(&dot, "digraph dag {")
+	fmt.Fprintln(&dot, "  rankdir=LR;")
+	fmt.Fprintln(&dot, "  node [shape=circle];")
+	for i := 0; i <= len(textRunes); i++ {
+		fmt.Fprintf(&dot, "  %d;\n", i)
+	}
+	for _, edges := range lat.Edges {
+		for _, e := range edges {
+			word := string(textRunes[e.Start:e.End])
+			label := fmt.Sprintf("%s %.4f", word, tk.unigramScore(word, logSize))
+			style := ""
+			if onPath[[2]int{e.Start, e.End}] {
+				style = ", color=red, penwidth=2"
+			}
+			fmt.Fprintf(&dot, "  %d -> %d [label=%q%s];\n", e.Start, e.End, label, style)
+		}
+	}
+	fmt.Fprintln(&dot, "}")
+	return dot.String()
+}
+
+// Return a human-readable report of how each Han block of `text`
+// is cut: the candidate words at each position with their log
+// probabilities, the best path, and the runs of single characters
+// that HMM re-segments. Like BuildLattice, it's read-only.
+func (tk *Tokenizer) ExplainCut(text string) string {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	runes := []rune(text)
+	report := strings.Builder{}
+	for _, block := range tk.splitRunes(runes) {
+		if !block.doProcess {
+			continue
+		}
+		blockRunes := runes[block.start:block.end]
+		lat := tk.buildLattice(blockRunes)
+		fmt.Fprintf(&report, "block %q\n", lat.Text)
+		fmt.Fprintln(&report, "  edges:")
+		for i, edges := range lat.Edges {
+			fmt.Fprintf(&report, "    %d %c:", i, blockRunes[i])
+			for _, e := range edges {
+				fmt.Fprintf(&report, " %s[%d:%d]=%.4f", string(blockRunes[e.Start:e.End]), e.Start, e.End, e.Proba)
+			}
+			fmt.Fprintln(&report)
+		}
+		pieces := []string{}
+		for _, p := range lat.Path {
+			pieces = append(pieces, string(blockRunes[p[0]:p[1]]))
+		}
+		fmt.Fprintf(&report, "  path: %s\n", strings.Join(pieces, " / "))
+		if !tk.HasHMM() {
+			continue
+		}
+		// Runs of single-rune pieces are what cutZh hands to HMM.
+		uncut := []rune{}
+		for i, p := range pieces {
+			pieceRunes := []rune(p)
+			if len(pieceRunes) == 1 {
+				uncut = append(uncut, pieceRunes[0])
+			}
+			if len(pieceRunes) == 1 && i+1 < len(pieces) {
+				continue
+			}
+			if len(uncut) != 0 {
+				words := tk.cutHMM(string(uncut), tk.viterbi(string(uncut)))
+				fmt.Fprintf(&report, "  hmm: %s -> %s\n", string(uncut), strings.Join(words, " / "))
+				uncut = nil
+			}
+		}
+	}
+	return report.String()
+}
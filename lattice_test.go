@@ -0,0 +1,199 @@
+package tokenizer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBuildLattice(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天 50 n",
+		"天天 20 d",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lat := tk.BuildLattice("今天天氣很好")
+
+	wantEdges := [][][2]int{
+		{{0, 1}, {0, 2}}, // 今, 今天
+		{{1, 2}, {1, 3}}, // 天, 天天
+		{{2, 3}, {2, 4}}, // 天, 天氣
+		{{3, 4}},         // 氣
+		{{4, 5}},         // 很
+		{{5, 6}},         // 好
+	}
+	gotEdges := [][][2]int{}
+	for _, edges := range lat.Edges {
+		spans := [][2]int{}
+		for _, e := range edges {
+			spans = append(spans, [2]int{e.Start, e.End})
+		}
+		gotEdges = append(gotEdges, spans)
+	}
+	assertDeepEqual(t, wantEdges, gotEdges)
+
+	wantPath := [][2]int{{0, 2}, {2, 4}, {4, 5}, {5, 6}}
+	assertDeepEqual(t, wantPath, lat.Path)
+	assertEqual(t, "今天天氣很好", lat.Text)
+}
+
+func TestCandidatesAt(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"量 30 n",
+		"量子 50 n",
+		"量子力学 20 n",
+		"子 10 n",
+		"力学 40 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "我量子力學"
+	want := []WordFreq{{"量", 30}, {"量子", 50}}
+	assertDeepEqual(t, want, tk.CandidatesAt(text, 1))
+	assertDeepEqual(t, []WordFreq{{"子", 10}}, tk.CandidatesAt(text, 2))
+	// Not a word, or out of range.
+	assertDeepEqual(t, []WordFreq{}, tk.CandidatesAt(text, 0))
+	assertDeepEqual(t, []WordFreq(nil), tk.CandidatesAt(text, 5))
+	assertDeepEqual(t, []WordFreq(nil), tk.CandidatesAt(text, -1))
+
+	want = []WordFreq{{"量", 30}, {"量子", 50}, {"量子力学", 20}}
+	assertDeepEqual(t, want, tk.CandidatesAt("量子力学", 0))
+}
+
+func TestExplainCut(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天 50 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.pd.size = 1000
+	got := tk.ExplainCut("abc今天好, 天")
+	p := tk.BuildLattice("今天好").Edges
+	want := fmt.Sprintf(`block "今天好"
+  edges:
+    0 今: 今[0:1]=%.4f 今天[0:2]=%.4f
+    1 天: 天[1:2]=%.4f
+    2 好: 好[2:3]=%.4f
+  path: 今天 / 好
+block "天"
+  edges:
+    0 天: 天[0:1]=%.4f
+  path: 天
+`, p[0][0].Proba, p[0][1].Proba, p[1][0].Proba, p[2][0].Proba, tk.BuildLattice("天").Edges[0][0].Proba)
+	assertEqual(t, want, got)
+
+	tk.hmm = newTestHMM(map[string]map[string]float64{})
+	got = tk.ExplainCut("今天好天")
+	if !strings.Contains(got, "  hmm: 好天 -> ") {
+		t.Errorf("want HMM line in report, got:\n%s", got)
+	}
+}
+
+func TestDAGToDOT(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"上 10 f",
+		"上海 50 ns",
+		"海 40 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logSize := math.Log(100)
+	want := fmt.Sprintf(`digraph dag {
+  rankdir=LR;
+  node [shape=circle];
+  0;
+  1;
+  2;
+  0 -> 1 [label="上 %.4f"];
+  0 -> 2 [label="上海 %.4f", color=red, penwidth=2];
+  1 -> 2 [label="海 %.4f"];
+}
+`, math.Log(10)-logSize, math.Log(50)-logSize, math.Log(40)-logSize)
+	assertEqual(t, want, tk.DAGToDOT("上海"))
+}
+
+func TestScoreSegmentation(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"研究 100 vn",
+		"研究生 20 n",
+		"生命 80 n",
+		"命 5 n",
+		"起源 50 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "研究生命起源"
+	got := tk.Cut(text, false)
+	assertDeepEqual(t, []string{"研究", "生命", "起源"}, got)
+	alt := []string{"研究生", "命", "起源"}
+	if tk.ScoreSegmentation(got) < tk.ScoreSegmentation(alt) {
+		t.Errorf("want %v to score no lower than %v", got, alt)
+	}
+
+	// The score of Cut's output is its path's score in the
+	// lattice.
+	lat := tk.BuildLattice(text)
+	if diff := lat.Edges[0][0].Proba - tk.ScoreSegmentation(got); math.Abs(diff) > 1e-9 {
+		t.Errorf("want the lattice's path score, got a difference of %v", diff)
+	}
+
+	// Unknown tokens count as frequency 1.
+	want := 2 * (math.Log(1) - math.Log(float64(tk.pd.size)))
+	assertEqual(t, want, tk.ScoreSegmentation([]string{"甲", "乙"}))
+}
+
+func TestFindWords(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"上 100",
+		"上海 80",
+		"海 50",
+		"交 30",
+		"交通 60",
+		"交通大学 20",
+		"通 20",
+		"大 90",
+		"大学 70",
+		"学 40",
+		"上海交通大学 10",
+		"上海交 0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{
+		{Word: "上", Start: 2, End: 3},
+		{Word: "上海", Start: 2, End: 4},
+		{Word: "上海交通大学", Start: 2, End: 8},
+		{Word: "海", Start: 3, End: 4},
+		{Word: "交", Start: 4, End: 5},
+		{Word: "交通", Start: 4, End: 6},
+		{Word: "交通大学", Start: 4, End: 8},
+		{Word: "通", Start: 5, End: 6},
+		{Word: "大", Start: 6, End: 7},
+		{Word: "大学", Start: 6, End: 8},
+		{Word: "学", Start: 7, End: 8},
+	}
+	assertDeepEqual(t, want, tk.FindWords("去 上海交通大学"))
+	assertDeepEqual(t, []Token{}, tk.FindWords("abc"))
+}
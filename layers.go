@@ -0,0 +1,53 @@
+package tokenizer
+
+// Dictionary is a set of words and their frequencies, to be
+// looked up before a tokenizer's own prefix dictionary. See
+// SetLayers.
+type Dictionary struct {
+	pd *prefixDictionary
+}
+
+// Load a dictionary file, in the format of NewTokenizer. A
+// frequency beyond the range of int is an error, as with
+// FreqOverflowError.
+func LoadDictionary(filename string) (*Dictionary, error) {
+	pd, err := loadPrefixDictionaryFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if pd.overflow != nil {
+		return nil, pd.overflow
+	}
+	return &Dictionary{pd}, nil
+}
+
+// Build a dictionary from words and their frequencies. Words with
+// a frequency below 1 are left out.
+func NewDictionary(words map[string]int) *Dictionary {
+	pd := prefixDictionary{termFreq: make(map[string]int, len(words)*2)}
+	for word, freq := range words {
+		if freq > 0 {
+			pd.insertTerm(word, freq)
+		}
+	}
+	pd.ready = true
+	return &Dictionary{&pd}
+}
+
+// Look words up in `layers`, in order, before the prefix
+// dictionary: the first one that has a word decides its
+// frequency. Frequencies are scored against the size of the
+// prefix dictionary, so they should be on the same scale. The
+// layers are never changed, so they can be shared by any number
+// of tokenizers, and swapped, e.g. per request, without merging
+// them into the prefix dictionary. FoldCase doesn't apply to
+// them. No layers means the prefix dictionary alone.
+func (tk *Tokenizer) SetLayers(layers ...*Dictionary) {
+	tk.pd.lock.Lock()
+	defer tk.pd.lock.Unlock()
+	tk.layers = nil
+	for _, layer := range layers {
+		tk.layers = append(tk.layers, layer.pd)
+	}
+	tk.clearCache()
+}
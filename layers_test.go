@@ -0,0 +1,35 @@
+package tokenizer
+
+import "testing"
+
+func TestSetLayers(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"研究 100 vn",
+		"研究生 20 n",
+		"生命 80 n",
+		"命 5 n",
+		"起源 50 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "研究生命起源"
+	assertDeepEqual(t, []string{"研究", "生命", "起源"}, tk.Cut(text, false))
+
+	// The domain dictionary favors 研究生, and adds 命起源,
+	// whose pieces the base dictionary lacks.
+	domain := NewDictionary(map[string]int{"研究生": 5000, "命起源": 10})
+	tk.SetLayers(domain)
+	assertDeepEqual(t, []string{"研究生", "命起源"}, tk.Cut(text, false))
+	general := NewDictionary(map[string]int{"研究生": 1, "生命": 9000})
+	tk.SetLayers(general, domain)
+	assertDeepEqual(t, []string{"研究", "生命", "起源"}, tk.Cut(text, false))
+
+	// The base dictionary is unchanged.
+	tk.SetLayers()
+	assertDeepEqual(t, []string{"研究", "生命", "起源"}, tk.Cut(text, false))
+	assertEqual(t, 20, tk.pd.termFreq["研究生"])
+	_, found := tk.pd.termFreq["命起"]
+	assertEqual(t, false, found)
+}
@@ -0,0 +1,63 @@
+package tokenizer
+
+// Return the longest dictionary word that starts at rune `pos`
+// of `text`, and the rune index where it ends. If no word starts
+// there, word is empty and end equals pos.
+func (tk *Tokenizer) LongestMatch(text string, pos int) (word string, end int) {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	runes := []rune(text)
+	if pos < 0 || pos >= len(runes) {
+		return "", pos
+	}
+	end = tk.longestMatch(tk.mapRunes(runes), pos)
+	return string(runes[pos:end]), end
+}
+
+// Cut text by forward maximum matching: take the longest
+// dictionary word at each position, or a single rune if there's
+// none. Probabilities are ignored, so it's deterministic and
+// makes an explainable baseline for Cut. Like Cut, it looks up
+// runes converted by WithS2TMapping or WithT2SMapping, skips
+// the words of WithBlocklist, and cuts non-Han blocks the same
+// way.
+func (tk *Tokenizer) CutLongest(text string) []string {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	runes := []rune(text)
+	result := []string{}
+	for _, block := range tk.splitRunes(runes) {
+		if !block.doProcess {
+			result = append(result, tk.cutNonZh(string(runes[block.start:block.end]))...)
+			continue
+		}
+		blockRunes := runes[block.start:block.end]
+		lookupRunes := tk.mapRunes(blockRunes)
+		for i := 0; i < len(blockRunes); {
+			end := tk.longestMatch(lookupRunes, i)
+			if end == i {
+				end = i + 1
+			}
+			result = append(result, string(blockRunes[i:end]))
+			i = end
+		}
+	}
+	return result
+}
+
+// Return the end of the longest word with a frequency above 0
+// that starts at runes[pos] and isn't blocklisted, or pos if
+// there's none. runes are looked up as they are, so map them
+// with mapRunes first. Callers must hold tk.pd.lock.
+func (tk *Tokenizer) longestMatch(runes []rune, pos int) int {
+	ws := dagWorkspaces.Get().(*dagWorkspace)
+	defer dagWorkspaces.Put(ws)
+	// Tails are in ascending order.
+	tk.dagTails(runes, pos, tk.maxWordLen(), ws)
+	for k := len(ws.tails) - 1; k >= 0; k-- {
+		if ws.freqs[k] > 0 && !tk.blocklist[string(runes[pos:ws.tails[k]])] {
+			return ws.tails[k]
+		}
+	}
+	return pos
+}
@@ -0,0 +1,53 @@
+package tokenizer
+
+import "testing"
+
+func TestLongestMatch(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"研究 100 vn",
+		"研究生 20 n",
+		"生命 80 n",
+		"起源 50 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "研究生命起源"
+	cases := []struct {
+		pos      int
+		wantWord string
+		wantEnd  int
+	}{
+		{0, "研究生", 3},
+		{1, "", 1},
+		{2, "生命", 4},
+		{4, "起源", 6},
+		{6, "", 6},
+		{-1, "", -1},
+	}
+	for _, c := range cases {
+		word, end := tk.LongestMatch(text, c.pos)
+		assertEqual(t, c.wantWord, word)
+		assertEqual(t, c.wantEnd, end)
+	}
+
+	// Maximum matching takes 研究生 greedily, while Cut finds
+	// the more probable 研究 / 生命.
+	want := []string{"研究生", "命", "起源", "!"}
+	tk.KeepPunctuation = true
+	assertDeepEqual(t, want, tk.CutLongest("研究生命起源!"))
+	assertDeepEqual(t, []string{"研究", "生命", "起源"}, tk.Cut(text, false))
+	tk.KeepPunctuation = false
+
+	assertDeepEqual(t, tk.CutLongest("研究生命起源!"), tk.CutMM("研究生命起源!"))
+
+	// Runes are mapped and blocked words skipped, as in Cut.
+	WithT2SMapping(map[rune]rune{'硏': '研'})(&tk)
+	WithBlocklist([]string{"研究生"})(&tk)
+	word, end := tk.LongestMatch("硏究生命", 0)
+	assertEqual(t, "硏究", word)
+	assertEqual(t, 2, end)
+	want = []string{"硏究", "生命", "起源"}
+	assertDeepEqual(t, want, tk.CutLongest("硏究生命起源"))
+}
@@ -0,0 +1,66 @@
+package tokenizer
+
+import "unicode"
+
+// NormToken is a token as it appears in the text, and in the
+// normalized form that CutNormalized gives it, such as an index
+// term. Start and End are rune offsets into the original text;
+// End is exclusive.
+type NormToken struct {
+	Surface    string
+	Normalized string
+	Start      int
+	End        int
+}
+
+// Normalize text, then cut it, and return each token both as
+// the original text and normalized. Normalizing folds full-width
+// ASCII, such as Ａ or １, and the ideographic space to their
+// ASCII forms, lowercases letters, and converts runes with the
+// table of WithT2SMapping or WithS2TMapping, if any. Every rune
+// is normalized to exactly one rune, so offsets into the
+// normalized text are offsets into the original. For the same
+// reason, this isn't Unicode normalization: combining marks are
+// neither composed nor removed, so "cafe\u0301" and "café"
+// normalize to different tokens. Because the
+// normalized text is cut, "ＡＢＣ" is one token, as "abc" would
+// be, and forced segments must be given in normalized form.
+// The normalized text is cut with FoldCase set, so that
+// lowercased letters still match dictionary words such as
+// "AT&T".
+func (tk *Tokenizer) CutNormalized(text string, useHmm bool) []NormToken {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	runes := []rune(text)
+	normalized := make([]rune, len(runes))
+	for i, r := range runes {
+		normalized[i] = tk.normalizeRune(r)
+	}
+	folding := tk.copyForCall()
+	folding.FoldCase = true
+	folding.pd.folded = tk.pd.foldedKeys()
+	folding.pd.foldOnce.Do(func() {})
+	tokens := []NormToken{}
+	for _, t := range folding.tokenize(string(normalized), useHmm) {
+		tokens = append(tokens, NormToken{
+			Surface:    string(runes[t.Start:t.End]),
+			Normalized: t.Word,
+			Start:      t.Start,
+			End:        t.End,
+		})
+	}
+	return tokens
+}
+
+func (tk *Tokenizer) normalizeRune(r rune) rune {
+	switch {
+	case r == '　':
+		r = ' '
+	case r >= '！' && r <= '～':
+		r -= 0xfee0
+	}
+	if mapped, found := tk.runeMap[r]; found {
+		r = mapped
+	}
+	return unicode.ToLower(r)
+}
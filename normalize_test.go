@@ -0,0 +1,48 @@
+package tokenizer
+
+import "testing"
+
+func TestCutNormalized(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"这 30 r",
+		"台 20 q",
+		"电脑 40 n",
+		"很 50 d",
+		"好 90 a",
+		"卡拉OK 30 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithT2SMapping(map[rune]rune{'這': '这', '臺': '台', '電': '电', '腦': '脑'})(&tk)
+
+	tk.KeepPunctuation = true
+	got := tk.CutNormalized("這臺ＩＢＭ電腦 Very好！", false)
+	want := []NormToken{
+		{"這", "这", 0, 1},
+		{"臺", "台", 1, 2},
+		{"ＩＢＭ", "ibm", 2, 5},
+		{"電腦", "电脑", 5, 7},
+		{"Very", "very", 8, 12},
+		{"好", "好", 12, 13},
+		{"！", "!", 13, 14},
+	}
+	assertDeepEqual(t, want, got)
+
+	// The ideographic space is dropped like a space.
+	want = []NormToken{{"ａ１", "a1", 0, 2}, {"Ｂ", "b", 3, 4}}
+	assertDeepEqual(t, want, tk.CutNormalized("ａ１　Ｂ", false))
+
+	// Dictionary words with capitals still match once lowercased.
+	tk.MixedWords = true
+	want = []NormToken{{"卡拉ＯＫ", "卡拉ok", 0, 4}, {"很", "很", 4, 5}, {"好", "好", 5, 6}}
+	assertDeepEqual(t, want, tk.CutNormalized("卡拉ＯＫ很好", false))
+	assertEqual(t, false, tk.FoldCase)
+
+	// Combining marks aren't composed, unlike with NFC.
+	tk.MixedWords = false
+	tk.KeepDiacritics = true
+	want = []NormToken{{"CAFE\u0301", "cafe\u0301", 0, 5}, {"好", "好", 5, 6}, {"Café", "café", 6, 10}}
+	assertDeepEqual(t, want, tk.CutNormalized("CAFE\u0301好Café", false))
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package tokenizer
+
+// The race detector allocates on its own, so allocation counts
+// aren't checked under it.
+const raceEnabled = true
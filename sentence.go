@@ -0,0 +1,158 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Runes that always end a sentence.
+var sentenceDelimiters = map[rune]bool{
+	'。':  true,
+	'！':  true,
+	'？':  true,
+	'；':  true,
+	'\n': true,
+}
+
+// ASCII runes that end a sentence only when followed by a space
+// or the end of text, so that "3.5" or "a.b" stay intact.
+const asciiSentenceDelimiters = ".!?;"
+
+// Also end sentences at each of `runes`, on top of the default
+// Chinese and ASCII punctuation, e.g. '…' or Tibetan '།'. This
+// applies to CutSentences and to how CutReader splits its input.
+func WithSentenceDelimiters(runes []rune) Option {
+	return func(tk *Tokenizer) {
+		delimiters := make(map[rune]bool, len(sentenceDelimiters)+len(runes))
+		for r := range sentenceDelimiters {
+			delimiters[r] = true
+		}
+		for _, r := range runes {
+			delimiters[r] = true
+		}
+		tk.delimiters = delimiters
+	}
+}
+
+// Return the runes that always end a sentence.
+func (tk *Tokenizer) delimiterSet() map[rune]bool {
+	if tk.delimiters != nil {
+		return tk.delimiters
+	}
+	return sentenceDelimiters
+}
+
+// Cut text sentence by sentence, and return one slice of tokens
+// per sentence. The punctuation that ends a sentence is kept as
+// the last token(s) of that sentence. Empty sentences are skipped.
+func (tk *Tokenizer) CutSentences(text string, useHmm bool) [][]string {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	sentences := [][]string{}
+	for _, s := range splitSentences(text, tk.delimiterSet()) {
+		body, delimiter := s[0], s[1]
+		tokens := tk.cut(body, useHmm)
+		for _, r := range delimiter {
+			if unicode.IsSpace(r) {
+				continue
+			}
+			tokens = append(tokens, string(r))
+		}
+		if len(tokens) != 0 {
+			sentences = append(sentences, tokens)
+		}
+	}
+	return sentences
+}
+
+// Cut text line by line, and return one slice of tokens per
+// line, so that result[i] holds the tokens of line i. Lines end
+// with "\n" or "\r\n". Blank lines get an empty slice. Like
+// bufio.ScanLines, a final line break doesn't start a new line.
+func (tk *Tokenizer) CutParagraphs(text string, useHmm bool) [][]string {
+	if text == "" {
+		return [][]string{}
+	}
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	paragraphs := make([][]string, 0, len(lines))
+	for _, line := range lines {
+		tokens := tk.cut(strings.TrimSuffix(line, "\r"), useHmm)
+		if tokens == nil {
+			tokens = []string{}
+		}
+		paragraphs = append(paragraphs, tokens)
+	}
+	return paragraphs
+}
+
+// Split text into sentences. Each item is a pair of the sentence
+// body and the run of punctuation that ends it. The last item's
+// delimiter is empty if text doesn't end with punctuation.
+func splitSentences(text string, delimiters map[rune]bool) [][2]string {
+	sentences := [][2]string{}
+	start := 0
+	for i := 0; i < len(text); {
+		end := sentenceEnd(text, i, delimiters)
+		if end == i {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			i += size
+			continue
+		}
+		sentences = append(sentences, [2]string{text[start:i], text[i:end]})
+		start = end
+		i = end
+	}
+	if start < len(text) {
+		sentences = append(sentences, [2]string{text[start:], ""})
+	}
+	return sentences
+}
+
+// If a sentence ends at byte `i` of text, return the byte index
+// after the run of punctuation that ends it. Otherwise return i.
+// Runes in `delimiters` always end a sentence. text may be a
+// byte slice, such as a bufio.Scanner's buffer, so that it isn't
+// copied to a string.
+func sentenceEnd[T string | []byte](text T, i int, delimiters map[rune]bool) int {
+	r, size := decodeRune(text[i:])
+	if strings.ContainsRune(asciiSentenceDelimiters, r) {
+		end := i + size
+		for end < len(text) && strings.ContainsRune(asciiSentenceDelimiters, rune(text[end])) {
+			end++
+		}
+		if end < len(text) {
+			next, _ := decodeRune(text[end:])
+			if !unicode.IsSpace(next) {
+				return i
+			}
+		}
+		return end
+	}
+	if !delimiters[r] {
+		return i
+	}
+	end := i + size
+	for end < len(text) {
+		next, size := decodeRune(text[end:])
+		if !delimiters[next] && !strings.ContainsRune(asciiSentenceDelimiters, next) {
+			break
+		}
+		end += size
+	}
+	return end
+}
+
+// Decode the first rune of text, like utf8.DecodeRuneInString
+// or utf8.DecodeRune.
+func decodeRune[T string | []byte](text T) (rune, int) {
+	switch t := interface{}(text).(type) {
+	case string:
+		return utf8.DecodeRuneInString(t)
+	case []byte:
+		return utf8.DecodeRune(t)
+	}
+	return utf8.RuneError, 0
+}
@@ -0,0 +1,110 @@
+package tokenizer
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestCutSentences(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "今天很好。Hello world! 3.5好？！\n好;ok"
+	want := [][]string{
+		{"今天", "很", "好", "。"},
+		{"Hello", "world", "!"},
+		{"3", ".", "5", "好", "？", "！"},
+		{"好", ";", "ok"},
+	}
+	got := tk.CutSentences(text, false)
+	assertDeepEqual(t, want, got)
+}
+
+func TestWithSentenceDelimiters(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 100 t",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "今天很好…好。好"
+	want := [][]string{
+		{"今天", "很", "好", "好", "。"},
+		{"好"},
+	}
+	assertDeepEqual(t, want, tk.CutSentences(text, false))
+
+	WithSentenceDelimiters([]rune{'…'})(&tk)
+	want = [][]string{
+		{"今天", "很", "好", "…"},
+		{"好", "。"},
+		{"好"},
+	}
+	assertDeepEqual(t, want, tk.CutSentences(text, false))
+	// The defaults still apply, and the shared set is unchanged.
+	assertEqual(t, false, sentenceDelimiters['…'])
+
+	// CutReader's chunks end at the new delimiter too.
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Split(scanSentences(100, tk.delimiterSet()))
+	chunks := []string{}
+	for scanner.Scan() {
+		chunks = append(chunks, scanner.Text())
+	}
+	assertDeepEqual(t, []string{"今天很好…", "好。", "好"}, chunks)
+}
+
+func TestCutParagraphs(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 100 t",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "今天天氣很好。\r\n\nhello world\n  \n好\n"
+	want := [][]string{
+		{"今天", "天氣", "很", "好"},
+		{},
+		{"hello", "world"},
+		{},
+		{"好"},
+	}
+	assertDeepEqual(t, want, tk.CutParagraphs(text, false))
+	assertDeepEqual(t, [][]string{}, tk.CutParagraphs("", false))
+	assertDeepEqual(t, [][]string{{}, {}}, tk.CutParagraphs("\n\n", false))
+}
+
+func TestSplitSentences(t *testing.T) {
+	cases := []struct {
+		text string
+		want [][2]string
+	}{
+		{"好。好", [][2]string{{"好", "。"}, {"好", ""}}},
+		{"a. b", [][2]string{{"a", "."}, {" b", ""}}},
+		{"a.b", [][2]string{{"a.b", ""}}},
+		{"wait... ok?", [][2]string{{"wait", "..."}, {" ok", "?"}}},
+		{"好！\n\n好", [][2]string{{"好", "！\n\n"}, {"好", ""}}},
+		{"", [][2]string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			got := splitSentences(c.text, sentenceDelimiters)
+			assertDeepEqual(t, c.want, got)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package tokenizer
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"unicode"
+)
+
+// Everything Save keeps of a Tokenizer, in a form gob can encode.
+type tokenizerSnapshot struct {
+	KeepURLs bool
+	Units    map[string]bool
+	// gob drops empty maps, but an empty Units still enables
+	// KeepNumbers.
+	HasUnits        bool
+	MergeParticles  map[string]bool
+	KeepNumbers     bool
+	MergeNumerals   bool
+	FoldCase        bool
+	CollapseRepeats bool
+	KeepPunctuation bool
+	Pinyin          bool
+	KeepDiacritics  bool
+	SplitAlphaNum   bool
+	Newlines        NewlineMode
+	GroupUnknown    bool
+	GroupCategories []*unicode.RangeTable
+	MarkUnknownHan  bool
+	MixedWords      bool
+	HMMMinLen       int
+	MaxWordLen      int
+	MaxTokenLen     int
+	DefaultPOS      string
+
+	TermFreq map[string]int
+	Size     int64
+	Source   string
+	POS      map[string]string
+
+	// The HMM's tables, or why there is no HMM.
+	StartP map[string]float64
+	TransP map[string]map[string]float64
+	EmitP  map[string]map[string]float64
+	HMMErr string
+
+	IDF            map[string]float64
+	DefaultIDF     float64
+	LengthBonus    float64
+	MinFreq        int
+	FreqOverflow   FreqOverflow
+	RuneMap        map[rune]rune
+	HMMRuneMap     map[rune]rune
+	Forced         map[string][]string
+	BigramCounts   map[[2]string]int
+	BigramTotals   map[string]int
+	UserDictWeight float64
+	Blocklist      map[string]bool
+	KeepChars      map[rune]bool
+	CacheSize      int
+	Delimiters     map[rune]bool
+}
+
+// Save the tokenizer to a file, with its dictionary, including
+// words added since it was built, its HMM and its options, so
+// that Load can restore it without loading the original files
+// again. EdgeScorer, HanRunes and the layers set by SetLayers
+// are not saved.
+func (tk *Tokenizer) Save(path string) error {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	s := tokenizerSnapshot{
+		KeepURLs:        tk.KeepURLs,
+		Units:           tk.Units,
+		HasUnits:        tk.Units != nil,
+		MergeParticles:  tk.MergeParticles,
+		KeepNumbers:     tk.KeepNumbers,
+		MergeNumerals:   tk.MergeNumerals,
+		FoldCase:        tk.FoldCase,
+		CollapseRepeats: tk.CollapseRepeats,
+		KeepPunctuation: tk.KeepPunctuation,
+		Pinyin:          tk.Pinyin,
+		KeepDiacritics:  tk.KeepDiacritics,
+		SplitAlphaNum:   tk.SplitAlphaNum,
+		Newlines:        tk.Newlines,
+		GroupUnknown:    tk.GroupUnknown,
+		GroupCategories: tk.GroupCategories,
+		MarkUnknownHan:  tk.MarkUnknownHan,
+		MixedWords:      tk.MixedWords,
+		HMMMinLen:       tk.HMMMinLen,
+		MaxWordLen:      tk.MaxWordLen,
+		MaxTokenLen:     tk.MaxTokenLen,
+		DefaultPOS:      tk.DefaultPOS,
+
+		TermFreq: tk.pd.termFreq,
+		Size:     tk.pd.size,
+		Source:   tk.pd.source,
+		POS:      tk.pd.pos,
+
+		StartP: tk.hmm.startP,
+		TransP: tk.hmm.transP,
+		EmitP:  tk.hmm.emitP,
+
+		IDF:            tk.idf,
+		DefaultIDF:     tk.defaultIDF,
+		LengthBonus:    tk.lengthBonus,
+		MinFreq:        tk.minFreq,
+		FreqOverflow:   tk.freqOverflow,
+		RuneMap:        tk.runeMap,
+		HMMRuneMap:     tk.hmmRuneMap,
+		UserDictWeight: tk.userDictWeight,
+		Blocklist:      tk.blocklist,
+		KeepChars:      tk.keepChars,
+		Delimiters:     tk.delimiters,
+	}
+	if tk.hmmErr != nil {
+		s.HMMErr = tk.hmmErr.Error()
+	}
+	if tk.forced != nil {
+		s.Forced = tk.forced.tokens
+	}
+	if tk.bigrams != nil {
+		s.BigramCounts = tk.bigrams.counts
+		s.BigramTotals = tk.bigrams.totals
+	}
+	if tk.cache != nil {
+		s.CacheSize = tk.cache.size
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(file).Encode(&s); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Load a tokenizer saved by Save.
+func Load(path string) (*Tokenizer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	s := tokenizerSnapshot{}
+	if err := gob.NewDecoder(file).Decode(&s); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrDecode, path, err)
+	}
+	if len(s.TermFreq) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrEmptyDictionary)
+	}
+
+	tk := Tokenizer{
+		KeepURLs:        s.KeepURLs,
+		Units:           s.Units,
+		MergeParticles:  s.MergeParticles,
+		KeepNumbers:     s.KeepNumbers,
+		MergeNumerals:   s.MergeNumerals,
+		FoldCase:        s.FoldCase,
+		CollapseRepeats: s.CollapseRepeats,
+		KeepPunctuation: s.KeepPunctuation,
+		Pinyin:          s.Pinyin,
+		KeepDiacritics:  s.KeepDiacritics,
+		SplitAlphaNum:   s.SplitAlphaNum,
+		Newlines:        s.Newlines,
+		GroupUnknown:    s.GroupUnknown,
+		GroupCategories: s.GroupCategories,
+		MarkUnknownHan:  s.MarkUnknownHan,
+		MixedWords:      s.MixedWords,
+		HMMMinLen:       s.HMMMinLen,
+		MaxWordLen:      s.MaxWordLen,
+		MaxTokenLen:     s.MaxTokenLen,
+		DefaultPOS:      s.DefaultPOS,
+
+		idf:            s.IDF,
+		defaultIDF:     s.DefaultIDF,
+		lengthBonus:    s.LengthBonus,
+		minFreq:        s.MinFreq,
+		freqOverflow:   s.FreqOverflow,
+		runeMap:        s.RuneMap,
+		hmmRuneMap:     s.HMMRuneMap,
+		userDictWeight: s.UserDictWeight,
+		blocklist:      s.Blocklist,
+		keepChars:      s.KeepChars,
+		delimiters:     s.Delimiters,
+	}
+	if s.HasUnits && tk.Units == nil {
+		tk.Units = map[string]bool{}
+	}
+	tk.pd.termFreq = s.TermFreq
+	tk.pd.size = s.Size
+	tk.pd.source = s.Source
+	tk.pd.pos = s.POS
+	tk.pd.ready = true
+	if s.HMMErr != "" {
+		tk.hmmErr = errors.New(s.HMMErr)
+	} else if s.StartP != nil {
+		tk.hmm = newHMM(s.StartP, s.TransP, s.EmitP)
+	}
+	WithForcedSegments(s.Forced)(&tk)
+	if s.BigramCounts != nil {
+		tk.bigrams = &bigramTable{counts: s.BigramCounts, totals: s.BigramTotals}
+	}
+	WithCache(s.CacheSize)(&tk)
+	tk.ready = true
+	return &tk, nil
+}
@@ -0,0 +1,65 @@
+package tokenizer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode"
+)
+
+func TestSaveLoad(t *testing.T) {
+	tk := Tokenizer{}
+	WithForcedSegments(map[string][]string{"大学生": {"大学", "生"}})(&tk)
+	WithBlocklist([]string{"天天"})(&tk)
+	WithCache(10)(&tk)
+	err := tk.buildPrefixDictionary([]string{
+		"今天 100 t",
+		"天 50 n",
+		"天天 20 d",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+		"大学 40 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.hmm = newTestHMM(map[string]map[string]float64{
+		"B": {"王": -1.0},
+		"E": {"明": -1.0},
+	})
+	tk.Units = map[string]bool{}
+	tk.GroupCategories = []*unicode.RangeTable{unicode.P}
+	tk.DefaultPOS = "x"
+	tk.AddWordPOS("很好", 200, "a")
+
+	path := filepath.Join(t.TempDir(), "tokenizer.gob")
+	if err := tk.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, true, loaded.Ready())
+	assertEqual(t, true, loaded.HasHMM())
+	texts := []string{
+		"今天天天氣很好",
+		"王明是大学生...3.5公斤",
+		"abc 今天王明很好！！",
+	}
+	for _, text := range texts {
+		for _, hmm := range []bool{false, true} {
+			assertDeepEqual(t, tk.Tokenize(text, hmm), loaded.Tokenize(text, hmm))
+		}
+	}
+	assertEqual(t, tk.pd.size, loaded.pd.size)
+	assertEqual(t, 10, loaded.cache.size)
+
+	os.WriteFile(path, []byte("not a gob"), 0o644)
+	_, err = Load(path)
+	if !errors.Is(err, ErrDecode) {
+		t.Errorf("want ErrDecode, got %v", err)
+	}
+}
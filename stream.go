@@ -0,0 +1,301 @@
+package tokenizer
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Largest chunk of text CutReader cuts at once. A sentence longer
+// than this is cut in pieces, which may split a word.
+const maxChunkSize = 1 << 20
+
+// Cut text read from r, and call fn with each token. Text is cut
+// one sentence at a time, so memory use is bounded by the longest
+// sentence rather than the size of the input. Stop early if fn
+// returns false.
+func (tk *Tokenizer) CutReader(r io.Reader, useHmm bool, fn func(token string) bool) error {
+	return tk.CutReaderProgress(r, useHmm, fn, nil)
+}
+
+// Like CutReader, and call progress with the number of bytes of
+// r consumed so far after each sentence is cut, e.g. to drive a
+// progress bar. At the end of r, the count is the size of r.
+// progress may be nil.
+func (tk *Tokenizer) CutReaderProgress(r io.Reader, useHmm bool, fn func(token string) bool, progress func(consumed int64)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxChunkSize)
+	scanner.Split(scanSentences(maxChunkSize, tk.delimiterSet()))
+	consumed := int64(0)
+	cut := func(text string) bool {
+		for _, token := range tk.Cut(text, useHmm) {
+			if !fn(token) {
+				return false
+			}
+		}
+		// scanSentences returns each chunk whole, so the text cut
+		// adds up to the input.
+		consumed += int64(len(text))
+		if progress != nil {
+			progress(consumed)
+		}
+		return true
+	}
+	// The text after the last Han rune of a sentence is held back
+	// and cut with the next one, so that a non-Han block that
+	// spans a sentence end, such as "！ok" in 好！ok, is cut whole,
+	// as Cut would.
+	pending := ""
+	for scanner.Scan() {
+		text := pending + scanner.Text()
+		end := tk.lastHanEnd(text)
+		if len(text)-end >= maxChunkSize {
+			end = len(text)
+		}
+		pending = text[end:]
+		if end > 0 && !cut(text[:end]) {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if pending != "" {
+		cut(pending)
+	}
+	return nil
+}
+
+// Return the byte index after the last Han rune of text that
+// isn't followed by an ASCII letter or digit, which may join it
+// under MixedWords or MergeNumerals, or 0 if there's none.
+func (tk *Tokenizer) lastHanEnd(text string) int {
+	next := ' '
+	for i := len(text); i > 0; {
+		r, size := utf8.DecodeLastRuneInString(text[:i])
+		if tk.isHan(r) && !isASCIILetter(next) && !unicode.IsDigit(next) {
+			return i
+		}
+		next = r
+		i -= size
+	}
+	return 0
+}
+
+// Cut text and call fn with each token as it's cut, without
+// building a slice of all tokens. Stop early if fn returns false.
+// fn is called with the tokenizer's read lock held, so it must
+// not modify the tokenizer, e.g. with AddWord.
+func (tk *Tokenizer) CutEach(text string, useHmm bool, fn func(token string) bool) {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	tk.cutEach(text, useHmm, fn)
+}
+
+// Like CutEach, but the caller must hold the read lock.
+func (tk *Tokenizer) cutEach(text string, useHmm bool, fn func(token string) bool) {
+	each := func(tokens []string) bool {
+		for _, token := range tokens {
+			if !fn(token) {
+				return false
+			}
+		}
+		return true
+	}
+	if tk.Newlines == NewlineDrop || !strings.Contains(text, "\n") {
+		tk.cutLineBlocks(text, useHmm, each)
+		return
+	}
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 && tk.Newlines == NewlineKeep && !fn("\n") {
+			return
+		}
+		if !tk.cutLineBlocks(line, useHmm, each) {
+			return
+		}
+	}
+}
+
+// Like cutTextBlocks, but also cut forced segments. Return false
+// if fn stopped early.
+func (tk *Tokenizer) cutLineBlocks(text string, useHmm bool, fn func(tokens []string) bool) bool {
+	if tk.forced == nil {
+		return tk.cutTextBlocks(text, useHmm, fn)
+	}
+	matches := tk.forced.pattern.FindAllStringIndex(text, -1)
+	for _, block := range splitText(text, matches) {
+		more := true
+		if block.doProcess {
+			more = fn(tk.chunkTokens(tk.forced.tokens[block.text]))
+		} else {
+			more = tk.cutTextBlocks(block.text, useHmm, fn)
+		}
+		if !more {
+			return false
+		}
+	}
+	return true
+}
+
+// Like cutText, but call fn with the tokens of each block as
+// they're cut. Return false if fn stopped early.
+func (tk *Tokenizer) cutTextBlocks(text string, useHmm bool, fn func(tokens []string) bool) bool {
+	if !tk.containsHan(text) {
+		tokens := tk.chunkTokens(tk.cutNonZh(text))
+		return len(tokens) == 0 || fn(tokens)
+	}
+	more := true
+	tk.cutBlocks([]rune(text), useHmm, nil, func(tokens []string) bool {
+		more = fn(tokens)
+		return more
+	})
+	return more
+}
+
+// Return a bufio.SplitFunc that ends each chunk after the
+// punctuation that ends a sentence. If maxChunk bytes are
+// buffered without a sentence end, the chunk ends at the last
+// complete rune instead. Runes in `delimiters` always end a
+// sentence.
+func scanSentences(maxChunk int, delimiters map[rune]bool) bufio.SplitFunc {
+	// Bytes at the start of data already known not to end a
+	// sentence, so that each call only looks at the data that
+	// arrived since the last one.
+	scanned := 0
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		// Look only at whole runes: a delimiter run or the rune
+		// after a "." may go on in the incomplete one.
+		whole := data
+		if !atEOF {
+			whole = data[:completeLen(data)]
+		}
+		i := scanned
+		for i < len(whole) {
+			end := sentenceEnd(whole, i, delimiters)
+			// Wait for more data if the delimiter run may go on,
+			// or if "." is at the end and may be a decimal point.
+			if end == len(whole) && !atEOF {
+				break
+			}
+			if end != i {
+				scanned = 0
+				return end, data[:end], nil
+			}
+			_, size := utf8.DecodeRune(whole[i:])
+			i += size
+		}
+		scanned = i
+		if atEOF {
+			scanned = 0
+			return len(data), data, nil
+		}
+		if len(data) >= maxChunk {
+			end := len(whole)
+			if end == 0 {
+				end = len(data)
+			}
+			scanned = 0
+			return end, data[:end], nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// Return the length of data without the incomplete rune at its
+// end, if any.
+func completeLen(data []byte) int {
+	start := len(data)
+	for start > 0 && !utf8.RuneStart(data[start-1]) {
+		start--
+	}
+	// data[start-1] starts the last rune.
+	if start > 0 && !utf8.FullRune(data[start-1:]) {
+		return start - 1
+	}
+	return len(data)
+}
+
+// Split text into chunks of at most maxChunk bytes, to be cut
+// one at a time, e.g. by Cut or CutEach. A chunk ends after the
+// punctuation that ends a sentence if one fits, else at the end
+// of the last word that fits whole, else at a rune boundary.
+// Chunks are never split inside a rune: a rune longer than
+// maxChunk gets a chunk of its own. Joined, the chunks are text.
+func (tk *Tokenizer) SplitForStreaming(text string, maxChunk int) []string {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	if maxChunk < 1 {
+		maxChunk = 1
+	}
+	delimiters := tk.delimiterSet()
+	chunks := []string{}
+	for len(text) > maxChunk {
+		end := tk.chunkEnd(text, maxChunk, delimiters)
+		chunks = append(chunks, text[:end])
+		text = text[end:]
+	}
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// Return where the first chunk of text ends. See
+// SplitForStreaming. len(text) must be over maxChunk.
+func (tk *Tokenizer) chunkEnd(text string, maxChunk int, delimiters map[rune]bool) int {
+	end := 0
+	for i := 0; i < maxChunk; {
+		if next := sentenceEnd(text, i, delimiters); next != i {
+			if next > maxChunk {
+				break
+			}
+			end = next
+			i = next
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	if end > 0 {
+		return end
+	}
+
+	limit := maxChunk
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	if limit == 0 {
+		_, size := utf8.DecodeRuneInString(text)
+		return size
+	}
+	// Cut past the limit, so that the word that crosses it is
+	// seen whole, and end at the last token that ends in time.
+	lookahead := limit
+	for n := 0; n < tk.maxWordLen() && lookahead < len(text); n++ {
+		_, size := utf8.DecodeRuneInString(text[lookahead:])
+		lookahead += size
+	}
+	window := text[:lookahead]
+	pos := 0
+	for _, token := range tk.cut(window, false) {
+		start := strings.Index(window[pos:], token)
+		if start < 0 {
+			break
+		}
+		tokenEnd := pos + start + len(token)
+		if tokenEnd > limit {
+			break
+		}
+		end = tokenEnd
+		pos = tokenEnd
+	}
+	if end > 0 {
+		return end
+	}
+	return limit
+}
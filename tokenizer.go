@@ -2,25 +2,88 @@ package tokenizer
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"math"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 const minFloat float64 = -3.14e100
 
+// Longer than any word in jieba's dictionary.
+const defaultMaxWordLen = 20
+
 var zh = regexp.MustCompile(`\p{Han}+`)
 var alnum = regexp.MustCompile(`([a-zA-Z0-9]+)`)
 
+// Like alnum, but letters and digits apart. See SplitAlphaNum.
+var alphaOrNum = regexp.MustCompile(`[a-zA-Z]+|[0-9]+`)
+var url = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[a-zA-Z0-9\-._~:/?#\[\]@!$&'()*+,;=%]+`)
+var email = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+
+// Numbers with a decimal point or thousands separators, such as
+// 3.5 or 1,000. Commas must separate groups of three digits, so
+// "1,2" is not a number.
+var numeral = regexp.MustCompile(`\b(?:[0-9]{1,3}(?:,[0-9]{3})+(?:\.[0-9]+)?|[0-9]+\.[0-9]+)\b`)
+var number = regexp.MustCompile(`^(?:` + numeral.String() + `|[0-9]+)$`)
+
+// Pinyin syllables with a tone mark or ü, such as wǒ or lüe4.
+// Syllables of ASCII letters and a tone number, such as wo3, are
+// already kept whole by alnum.
+const pinyinVowels = "üāáǎàēéěèīíǐìōóǒòūúǔùǖǘǚǜÜĀÁǍÀĒÉĚÈĪÍǏÌŌÓǑÒŪÚǓÙǕǗǙǛ"
+
+var pinyin = regexp.MustCompile(`[a-zA-Z]*[` + pinyinVowels + `][a-zA-Z` + pinyinVowels + `]*[1-5]?`)
+
+// Words of Latin letters, with or without diacritics, and
+// digits. A letter may be precomposed, such as é, or followed by
+// combining marks, such as e and U+0301.
+var latinWord = regexp.MustCompile(`[\p{Latin}0-9][\p{Latin}\p{Mn}0-9]*`)
+
+// Like latinWord, but letters and digits apart. See SplitAlphaNum.
+var latinOrNum = regexp.MustCompile(`\p{Latin}[\p{Latin}\p{Mn}]*|[0-9]+`)
+
+// NewlineMode is how Cut treats "\n". See Tokenizer.Newlines.
+type NewlineMode int
+
+const (
+	// Drop newlines like other whitespace.
+	NewlineDrop NewlineMode = iota
+	// Keep each newline as a "\n" token.
+	NewlineKeep
+	// Drop newlines, but cut each line on its own, so that no
+	// token, forced segment or merge spans two lines.
+	NewlineBreak
+)
+
+// Common Chinese measure words, for use as Tokenizer.Units.
+var DefaultUnits = map[string]bool{
+	"个": true, "十": true, "百": true, "千": true, "万": true, "亿": true,
+	"元": true, "块": true, "角": true, "毛": true, "美元": true, "万元": true,
+	"米": true, "厘米": true, "毫米": true, "公里": true, "千米": true,
+	"克": true, "千克": true, "公斤": true, "斤": true, "吨": true,
+	"升": true, "毫升": true,
+	"年": true, "月": true, "日": true, "天": true, "周": true,
+	"小时": true, "分钟": true, "秒": true, "岁": true,
+	"次": true, "件": true, "人": true, "本": true, "张": true,
+	"只": true, "台": true, "辆": true, "倍": true,
+}
+
 var stateChange = map[string][]string{
 	"B": {"E", "S"}, // E->B, S->B
 	"M": {"B", "M"},
@@ -50,43 +113,570 @@ type transitionRoute struct {
 }
 
 type Tokenizer struct {
+	// Keep URLs and email addresses as single tokens instead of
+	// splitting them at every punctuation character.
+	KeepURLs bool
+	// Measure words, such as 公斤 or 天, to keep together with an
+	// immediately preceding number. "3.5公斤" becomes one token.
+	// Nil disables unit merging. See DefaultUnits.
+	Units map[string]bool
+	// Function words, such as 的 or 了, to attach to the token
+	// right before them in the same Han block, for display. "好的"
+	// becomes one token even if the dictionary cuts it in two. A
+	// particle that starts a block stays on its own. Nil disables
+	// it.
+	MergeParticles map[string]bool
+	// Keep numbers such as 3.5 and 1,000 as single tokens. Dots
+	// and commas that are not between digits are still split.
+	// Also enabled by Units.
+	KeepNumbers bool
+	// Join digits with the Chinese numerals right next to them,
+	// such as 2千 or 3千5百, into one token.
+	MergeNumerals bool
+	// Match letters in dictionary words case-insensitively, so
+	// "at&t" finds "AT&T". Han characters are always matched
+	// exactly.
+	FoldCase bool
+	// Merge a run of the same single-rune token, such as 哈哈哈
+	// or !!!, into one token. Runs don't extend across spaces or
+	// between Han and non-Han text.
+	CollapseRepeats bool
+	// Keep blocks of non-Han text that have no letters or digits,
+	// such as the "，" in 今天，好, or kana. By default they're
+	// dropped, while punctuation next to letters or digits, as in
+	// "ok, 好", is kept.
+	KeepPunctuation bool
+	// Keep pinyin syllables with tone marks, such as wǒ, whole
+	// instead of splitting them at the marked vowel. Syllables
+	// with tone numbers, such as wo3, are always kept whole.
+	Pinyin bool
+	// Cut runs of ASCII letters and digits apart where letters
+	// meet digits, so "abc123" becomes "abc" and "123". Numbers
+	// kept whole by KeepNumbers stay whole.
+	SplitAlphaNum bool
+	// How newlines are cut. The default drops them.
+	Newlines NewlineMode
+	// Keep Latin letters with diacritics in the words they belong
+	// to, such as café, whether the accents are precomposed or
+	// combining marks. Text is not normalized to NFC, which would
+	// take golang.org/x/text, so tokens keep their original runes
+	// and offsets: "cafe\u0301" and "café" stay different tokens.
+	// With SplitAlphaNum, letters and digits are still cut apart.
+	KeepDiacritics bool
+	// Without HMM, keep a run of single runes that aren't
+	// dictionary words together as one token, instead of
+	// splitting it into single runes.
+	GroupUnknown bool
+	// Keep a run of runes outside Han and ASCII alphanumeric
+	// text together if they all belong to the same one of these
+	// Unicode categories, e.g. unicode.P keeps "..." and "！！！"
+	// whole, and unicode.S keeps "+++". Nil cuts them one rune
+	// at a time.
+	GroupCategories []*unicode.RangeTable
+	// Report Han tokens that aren't dictionary words as
+	// HanUnknown instead of Han in CutTyped, e.g. a lone 撙
+	// that was cut off because no word contains it.
+	MarkUnknownHan bool
+	// Match dictionary words that mix Han characters and ASCII
+	// letters, such as 江南style and 卡拉OK. Letters next to Han
+	// characters are cut with them instead of separately. A word
+	// only matches a whole run of letters: 江南styles is cut as
+	// 江南 and styles.
+	MixedWords bool
+	// Segment a run of single runes with HMM only if it's at
+	// least HMMMinLen runes long. Shorter runs stay single
+	// runes. 0 segments every run.
+	HMMMinLen int
+	// Longest word, in runes, that the prefix dictionary lookup
+	// will match. This bounds the cost of pathological input.
+	// Values below 1 mean defaultMaxWordLen.
+	MaxWordLen int
+	// Split tokens longer than MaxTokenLen runes into chunks of
+	// MaxTokenLen runes, and a shorter last chunk. This applies
+	// to every token, including forced segments. 0 disables it.
+	MaxTokenLen int
+	// Part-of-speech reported for words that weren't given one
+	// with AddWordPOS. See Token.POS.
+	DefaultPOS string
+	// Score of a candidate word in the DAG, given its frequency
+	// (0 if it isn't a word) and the dictionary's total size.
+	// The path with the highest total score is chosen. Nil
+	// means log(max(freq, 1)) - log(size).
+	EdgeScorer func(word string, freq int, size int64) float64
+	// Report whether a rune is cut with the dictionary and HMM,
+	// like Han text, rather than as non-Han text. Runs of such
+	// runes are cut as a block, so e.g. returning false for kana
+	// keeps it out of the dictionary lookups of the kanji next
+	// to it. Nil means unicode.Han.
+	HanRunes func(r rune) bool
+
 	ready bool
 	pd    prefixDictionary
 	hmm   hiddenMarkovModel
-	// Values below are for debugging.
-	dag      map[int][]int
-	dagProba map[int][]tailProba
+	// Why the HMM couldn't be loaded. See HasHMM.
+	hmmErr error
+	// First error of an Option, such as WithBigrams, that loads
+	// a file. Constructors return it, or panic with it.
+	optErr error
+	// IDF table for keyword extraction. See WithIDF.
+	idf        map[string]float64
+	defaultIDF float64
+	// Added to a word's log probability for each rune after the
+	// first. See WithLengthBonus.
+	lengthBonus float64
+	// Words below this frequency are ignored. See WithMinFreq.
+	minFreq int
+	// What loading a frequency beyond the range of int does. See
+	// WithFreqOverflow.
+	freqOverflow FreqOverflow
+	// Runes to replace before dictionary lookups. See
+	// WithS2TMapping and WithT2SMapping.
+	runeMap map[rune]rune
+	// Runes to replace before HMM emission lookups. See
+	// WithHMMMapping.
+	hmmRuneMap map[rune]rune
+	// Text to cut a fixed way. See WithForcedSegments.
+	forced *forcedSegments
+	// Optional word pair counts for scoring. See WithBigrams.
+	bigrams *bigramTable
+	// Dictionaries looked up before pd, highest priority first.
+	// See SetLayers.
+	layers []*prefixDictionary
+	// Recent Cut results. See WithCache.
+	cache *cutCache
+	// Words never to cut as tokens. See WithBlocklist.
+	blocklist map[string]bool
+	// Runes cut as tokens of their own. See WithKeepChars.
+	keepChars map[rune]bool
+	// Multiplies the frequencies of LoadUserDict. See
+	// WithUserDictWeight.
+	userDictWeight float64
+	// Runes that end a sentence. Nil means sentenceDelimiters.
+	// See WithSentenceDelimiters.
+	delimiters map[rune]bool
+	// Dictionary made by DictionaryBuilder.Build, or read by
+	// NewTokenizerWith, which Reset restores.
+	built *prefixDictionary
+	// Called by cutBlockSafely before cutting each block. Tests
+	// use it to inject failures.
+	cutBlockHook func(textBlock)
 }
 
-func NewTokenizer(dictionaryFile string) *Tokenizer {
+// Option configures a Tokenizer when it's constructed.
+type Option func(*Tokenizer)
+
+// Build a tokenizer from a dictionary file with one
+// "word freq [pos]" entry per line, and jieba's HMM. If the file
+// can't be loaded or has no words, NewTokenizer exits the
+// program through log.Fatal; NewTokenizerWith returns an error
+// instead.
+func NewTokenizer(dictionaryFile string, opts ...Option) *Tokenizer {
 	tk := Tokenizer{}
+	for _, opt := range opts {
+		opt(&tk)
+	}
+	if tk.optErr != nil {
+		panic(tk.optErr.Error())
+	}
 	tk.pd = *newPrefixDictionaryFromFile(dictionaryFile)
-	tk.hmm = newJiebaHMM()
+	if err := tk.checkFreqOverflow(&tk.pd); err != nil {
+		log.Fatal(err)
+	}
+	tk.pd.prune(tk.minFreq)
+	if err := tk.loadHMM(); err != nil {
+		panic(err.Error())
+	}
 	tk.ready = true
 	return &tk
 }
 
-func NewJiebaTokenizer() *Tokenizer {
+// HMM is a Hidden Markov model for cutting runs of runes that
+// aren't dictionary words, such as names. See NewHMM.
+type HMM struct {
+	model hiddenMarkovModel
+}
+
+// Build an HMM from the log probabilities of its states: "B"
+// begins a word, "M" is in the middle of one, "E" ends one, and
+// "S" is a word by itself. start gives each state's probability
+// at the first rune, trans[from][to] the probability of each
+// transition, and emit[state][rune] the probability of each rune
+// in a state. Runes missing from emit are nearly impossible.
+// Every state must have a start and transition probabilities.
+func NewHMM(start map[string]float64, trans, emit map[string]map[string]float64) (*HMM, error) {
+	for _, state := range []string{"B", "M", "E", "S"} {
+		if _, found := start[state]; !found {
+			return nil, fmt.Errorf("no start probability for state %s", state)
+		}
+		if len(trans[state]) == 0 {
+			return nil, fmt.Errorf("no transitions from state %s", state)
+		}
+	}
+	return &HMM{newHMM(start, trans, emit)}, nil
+}
+
+// Name that Source reports for a Tokenizer made by
+// NewTokenizerWith.
+const readerSource = "NewTokenizerWith"
+
+// Build a tokenizer from a dictionary read from r, in the format
+// of NewTokenizer, and a custom HMM, e.g. one trained for the
+// same domain as the dictionary. A nil hmm means cutting without
+// HMM only. Reset restores the dictionary read from r.
+func NewTokenizerWith(r io.Reader, hmm *HMM, opts ...Option) (*Tokenizer, error) {
 	tk := Tokenizer{}
+	for _, opt := range opts {
+		opt(&tk)
+	}
+	if tk.optErr != nil {
+		return nil, tk.optErr
+	}
+	pd, err := readPrefixDictionary(r, "dictionary", 0)
+	if err != nil {
+		return nil, err
+	}
+	if pd.size < 1 {
+		return nil, fmt.Errorf("dictionary: %w", ErrEmptyDictionary)
+	}
+	if err := tk.checkFreqOverflow(pd); err != nil {
+		return nil, err
+	}
+	pd.source = readerSource
+	tk.built = pd
+	pd = tk.built.share()
+	pd.prune(tk.minFreq)
+	tk.pd.termFreq = pd.termFreq
+	tk.pd.size = pd.size
+	tk.pd.ready = pd.ready
+	tk.pd.source = pd.source
+	tk.pd.shared = pd.shared
+	if hmm != nil {
+		tk.hmm = hmm.model
+	} else {
+		tk.hmmErr = errors.New("no HMM given to NewTokenizerWith")
+	}
+	tk.ready = true
+	return &tk, nil
+}
+
+// Errors returned by NewJiebaTokenizerE, NewTokenizerWith and
+// the functions that load dictionary files. A missing file is
+// reported with an error that wraps fs.ErrNotExist. A dictionary
+// is empty if it has no word with a frequency above 0; only a
+// tokenizer's base dictionary must have one, so
+// LoadDictionaries, LoadDictionary and the like accept empty
+// files.
+var (
+	ErrDecode          = errors.New("failed to decode")
+	ErrEmptyDictionary = errors.New("empty dictionary")
+)
+
+// Like NewJiebaTokenizer, but return an error instead of exiting
+// when jieba's dictionary or HMM can't be loaded.
+func NewJiebaTokenizerE(opts ...Option) (*Tokenizer, error) {
+	tk := Tokenizer{}
+	for _, opt := range opts {
+		opt(&tk)
+	}
+	if tk.optErr != nil {
+		return nil, tk.optErr
+	}
+	pd, err := cachedJiebaPrefixDictionary()
+	if err != nil {
+		return nil, err
+	}
+	if err := tk.loadHMM(); err != nil {
+		return nil, err
+	}
+	tk.pd.termFreq = pd.termFreq
+	tk.pd.size = pd.size
+	tk.pd.ready = pd.ready
+	tk.pd.source = pd.source
+	tk.pd.shared = pd.shared
+	tk.pd.prune(tk.minFreq)
+	tk.ready = true
+	return &tk, nil
+}
+
+func NewJiebaTokenizer(opts ...Option) *Tokenizer {
+	tk := Tokenizer{}
+	for _, opt := range opts {
+		opt(&tk)
+	}
+	if tk.optErr != nil {
+		panic(tk.optErr.Error())
+	}
 	tk.pd = *newJiebaPrefixDictionary()
-	tk.hmm = newJiebaHMM()
+	tk.pd.prune(tk.minFreq)
+	if err := tk.loadHMM(); err != nil {
+		panic(err.Error())
+	}
 	tk.ready = true
 	return &tk
 }
 
+// Load jieba's HMM. A missing HMM file isn't an error, because
+// text can still be cut without HMM; the error is kept and
+// reported by HasHMM.
+func (tk *Tokenizer) loadHMM() error {
+	return tk.setHMM(loadJiebaHMM())
+}
+
+// Set the HMM that loading returned. See loadHMM.
+func (tk *Tokenizer) setHMM(hmm hiddenMarkovModel, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		tk.hmmErr = err
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tk.hmm = hmm
+	return nil
+}
+
+// Report whether the tokenizer has an HMM to cut with. If its
+// HMM file was missing when it was built, or it has no HMM for
+// another reason, cutting with useHmm set to true cuts the same
+// as with useHmm set to false, and CutHMMOnly cuts Han text into
+// single runes.
+func (tk *Tokenizer) HasHMM() bool {
+	return tk.hmmErr == nil && tk.hmm.ready
+}
+
+// Run tk.hmm.viterbi. Without an HMM, every rune is a word of
+// its own.
+func (tk *Tokenizer) viterbi(text string) []string {
+	if !tk.HasHMM() {
+		states := []string{}
+		for range text {
+			states = append(states, "S")
+		}
+		return states
+	}
+	if tk.hmmRuneMap != nil {
+		textRunes := []rune(text)
+		for i, r := range textRunes {
+			if m, found := tk.hmmRuneMap[r]; found {
+				textRunes[i] = m
+			}
+		}
+		text = string(textRunes)
+	}
+	return tk.hmm.viterbi(text)
+}
+
+// Report whether the tokenizer was built by a constructor and
+// is ready to cut text.
+func (tk *Tokenizer) Ready() bool {
+	return tk.ready
+}
+
+// Return the dictionary the tokenizer was loaded from: the
+// file name given to NewTokenizer, "prefix_dictionary.gob"
+// for NewJiebaTokenizer, "NewTokenizerWith" for
+// NewTokenizerWith, or "DictionaryBuilder" for
+// DictionaryBuilder.Build.
+func (tk *Tokenizer) Source() string {
+	return tk.pd.source
+}
+
+// DictStats describes the prefix dictionary of a Tokenizer.
+type DictStats struct {
+	// Terms with a frequency above 0.
+	Words int
+	// Prefixes of words that are not words themselves.
+	Fragments int
+	// Sum of all frequencies.
+	Size int64
+}
+
+// Count the words and fragments in the prefix dictionary. It
+// scans every term, so it's meant for diagnostics, not for the
+// cutting path.
+func (tk *Tokenizer) Stats() DictStats {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	stats := DictStats{Size: tk.pd.size}
+	for _, freq := range tk.pd.termFreq {
+		if freq > 0 {
+			stats.Words++
+		} else {
+			stats.Fragments++
+		}
+	}
+	return stats
+}
+
+// Convert runes with `table` before looking up their emission
+// probabilities in the HMM, e.g. Traditional to Simplified for
+// jieba's HMM, which was trained on Simplified text and knows few
+// Traditional characters. Dictionary lookups are not affected;
+// see WithT2SMapping for those. Tokens keep the original runes.
+func WithHMMMapping(table map[rune]rune) Option {
+	return func(tk *Tokenizer) {
+		tk.hmmRuneMap = table
+	}
+}
+
+// Convert Simplified Chinese characters in the input to
+// Traditional ones with `table` before looking them up in the
+// dictionary, for a Traditional dictionary. Tokens keep the
+// original characters. It's the same option as WithT2SMapping:
+// the direction comes only from the table, and the last of the
+// two given wins.
+func WithS2TMapping(table map[rune]rune) Option {
+	return withRuneMapping(table)
+}
+
+// Convert Traditional Chinese characters in the input to
+// Simplified ones with `table` before looking them up in the
+// dictionary, for a Simplified dictionary, such as jieba's.
+// Tokens keep the original characters. See WithS2TMapping.
+func WithT2SMapping(table map[rune]rune) Option {
+	return withRuneMapping(table)
+}
+
+func withRuneMapping(table map[rune]rune) Option {
+	return func(tk *Tokenizer) {
+		tk.runeMap = table
+	}
+}
+
+// Ignore dictionary words with a frequency below n when the
+// dictionary is loaded, or reloaded by Reset. They no longer
+// count towards the dictionary size. Words added later, e.g.
+// with AddWord, are kept.
+func WithMinFreq(n int) Option {
+	return func(tk *Tokenizer) {
+		tk.minFreq = n
+	}
+}
+
+// FreqOverflow is what loading a dictionary file does with a
+// frequency beyond the range of int.
+type FreqOverflow int
+
+const (
+	// Fail with an error that names the line and wraps
+	// strconv.ErrRange.
+	FreqOverflowError FreqOverflow = iota
+	// Load the word with the largest int as its frequency.
+	FreqOverflowClamp
+)
+
+// Choose what loading a frequency beyond the range of int does,
+// in the dictionary of NewTokenizer, NewTokenizerWith or Reset,
+// and in LoadDictionaries. The default is FreqOverflowError.
+func WithFreqOverflow(policy FreqOverflow) Option {
+	return func(tk *Tokenizer) {
+		tk.freqOverflow = policy
+	}
+}
+
+// Return the first frequency of pd that was out of range, as an
+// error, unless tk clamps them. See WithFreqOverflow.
+func (tk *Tokenizer) checkFreqOverflow(pd *prefixDictionary) error {
+	if tk.freqOverflow == FreqOverflowClamp {
+		return nil
+	}
+	return pd.overflow
+}
+
+type forcedSegments struct {
+	// Matches any key of tokens, longest first.
+	pattern *regexp.Regexp
+	tokens  map[string][]string
+}
+
+// Cut each key of `segments` that appears in the text into the
+// given tokens, regardless of the dictionary. Where keys overlap,
+// the leftmost match wins, and the longest key at that position.
+// A key can include context, e.g. "北京大学生" cut as "北京" and
+// "大学生", to apply only there.
+func WithForcedSegments(segments map[string][]string) Option {
+	return func(tk *Tokenizer) {
+		keys := []string{}
+		for k := range segments {
+			if k != "" {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			tk.forced = nil
+			return
+		}
+		// Go's regexp prefers the first alternative that matches,
+		// so longer keys go first.
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) > len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		for i, k := range keys {
+			keys[i] = regexp.QuoteMeta(k)
+		}
+		tk.forced = &forcedSegments{
+			pattern: regexp.MustCompile(strings.Join(keys, "|")),
+			tokens:  segments,
+		}
+	}
+}
+
+// Never cut any of `words` as a token, if there's another way to
+// cut the text around it: paths through them score minFloat.
+// Unlike removing the words from the dictionary, longer words
+// that start with them are still found.
+func WithBlocklist(words []string) Option {
+	return func(tk *Tokenizer) {
+		tk.blocklist = map[string]bool{}
+		for _, word := range words {
+			tk.blocklist[word] = true
+		}
+	}
+}
+
+// Always cut each of `runes` outside Han text as a token of its
+// own, even where KeepURLs, KeepNumbers, CollapseRepeats or
+// GroupCategories would join it with its neighbors. Whitespace
+// runes given here are kept instead of dropped.
+func WithKeepChars(runes []rune) Option {
+	return func(tk *Tokenizer) {
+		tk.keepChars = map[rune]bool{}
+		for _, r := range runes {
+			tk.keepChars[r] = true
+		}
+	}
+}
+
+// Favor longer words by adding bonus to a candidate word's log
+// probability for each rune after its first. 0 disables the
+// bonus, and a negative bonus favors shorter words. A bonus
+// around 1 is usually enough to settle close calls.
+func WithLengthBonus(bonus float64) Option {
+	return func(tk *Tokenizer) {
+		tk.lengthBonus = bonus
+	}
+}
+
 // Perform Cut in worker goroutines in parallel.
 // If ordered is true, the returned slice will be sorted
 // according to the order of the input text. Sorting will
-// adversely impact performance by approximately 30%.
+// adversely impact performance by approximately 30%. Units and
+// MergeNumerals join tokens across blocks, so with either set,
+// the slice is always sorted.
+// Newlines are handled as in Cut, but forced segments are not
+// applied.
 func (tk *Tokenizer) CutParallel(text string, hmm bool, numWorkers int, ordered bool) []string {
 	tk.pd.lock.RLock()
 	defer tk.pd.lock.RUnlock()
 	// Split text into zh and non-zh blocks.
 	blocks := make(chan textBlock, len(text))
-	zhIndexes := zh.FindAllIndex([]byte(text), -1)
+	blockList := splitText(text, tk.hanIndexes(text))
 	go func() {
 		defer close(blocks)
-		for _, block := range splitText(text, zhIndexes) {
+		for _, block := range blockList {
 			blocks <- block
 		}
 	}()
@@ -107,7 +697,7 @@ func (tk *Tokenizer) CutParallel(text string, hmm bool, numWorkers int, ordered
 		defer close(result)
 		wg.Wait()
 	}()
-	if ordered {
+	if ordered || tk.joinsBlocks() {
 		// Collect `resultBlock` from `result`.
 		rblocks := []resultBlock{}
 		for rb := range result {
@@ -119,21 +709,104 @@ func (tk *Tokenizer) CutParallel(text string, hmm bool, numWorkers int, ordered
 		})
 		// Extract strings.
 		tokens := []string{}
+		joiner := blockJoiner{tk: tk}
 		for _, rb := range rblocks {
-			tokens = append(tokens, rb.tokens...)
+			done := joiner.addBlock(blockList[rb.id], rb.tokens)
+			tokens = append(tokens, tk.chunkTokens(done)...)
 		}
-		return tokens
+		return append(tokens, tk.chunkTokens(joiner.pending)...)
 	} else {
 		// Collect `resultBlock` from `result` and extract
 		// string tokens.
 		tokens := []string{}
 		for rb := range result {
-			tokens = append(tokens, rb.tokens...)
+			tokens = append(tokens, tk.chunkTokens(rb.tokens)...)
 		}
 		return tokens
 	}
 }
 
+// Perform Cut in worker goroutines in parallel, and send the
+// tokens to the returned channel in the order of the input
+// text. Each block's tokens are sent as soon as all the blocks
+// before it are done, so only blocks that finish out of order
+// are buffered. The channel is closed after the last token, or
+// once ctx is canceled.
+//
+// The tokenizer holds its read lock until the channel is closed,
+// so changes such as AddWord wait until then. A caller that
+// stops reading early must cancel ctx to release it.
+func (tk *Tokenizer) CutParallelOrdered(ctx context.Context, text string, hmm bool, numWorkers int) <-chan string {
+	tokens := make(chan string, numWorkers)
+	go func() {
+		defer close(tokens)
+		tk.pd.lock.RLock()
+		defer tk.pd.lock.RUnlock()
+		blocks := make(chan textBlock, numWorkers)
+		blockList := splitText(text, tk.hanIndexes(text))
+		// Workers read the dictionary, so they must be done
+		// before the lock is released.
+		wg := sync.WaitGroup{}
+		defer wg.Wait()
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			defer close(blocks)
+			for _, block := range blockList {
+				select {
+				case <-stop:
+					return
+				case blocks <- block:
+				}
+			}
+		}()
+		result := make(chan resultBlock, numWorkers)
+		wg.Add(numWorkers)
+		for i := 0; i < numWorkers; i++ {
+			go func() {
+				tk.worker(blocks, stop, result, hmm)
+				wg.Done()
+			}()
+		}
+		go func() {
+			defer close(result)
+			wg.Wait()
+		}()
+		send := func(ts []string) bool {
+			for _, t := range ts {
+				select {
+				case <-ctx.Done():
+					return false
+				case tokens <- t:
+				}
+			}
+			return true
+		}
+		// Hold blocks that finish early until the blocks
+		// before them are sent.
+		pending := map[int][]string{}
+		next := 0
+		joiner := blockJoiner{tk: tk}
+		for rb := range result {
+			pending[rb.id] = rb.tokens
+			for {
+				blockTokens, found := pending[next]
+				if !found {
+					break
+				}
+				done := joiner.addBlock(blockList[next], blockTokens)
+				if !send(tk.chunkTokens(done)) {
+					return
+				}
+				delete(pending, next)
+				next++
+			}
+		}
+		send(tk.chunkTokens(joiner.pending))
+	}()
+	return tokens
+}
+
 // Worker for CutParallel() method.
 // A worker fetches work from `blocks` channel, processes the
 // block, and sends the result to the `result` channel.
@@ -142,25 +815,792 @@ func (tk *Tokenizer) worker(blocks chan textBlock, stop chan struct{}, result ch
 		select {
 		case <-stop:
 			return
-		case result <- resultBlock{b.id, tk.cutBlock(b, hmm)}:
+		case result <- resultBlock{b.id, tk.cutBlockSafely(b, hmm)}:
 		}
 	}
 }
 
-// Cut text and return a slice of tokens.
+// Cut a block in a worker goroutine. A panic would crash the
+// whole program, so it's logged instead and the block yields
+// no tokens.
+func (tk *Tokenizer) cutBlockSafely(b textBlock, hmm bool) (tokens []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("failed to cut block %d %q: %v", b.id, b.text, r)
+			tokens = []string{}
+		}
+	}()
+	if tk.cutBlockHook != nil {
+		tk.cutBlockHook(b)
+	}
+	return tk.cutBlock(b, hmm)
+}
+
+// Cut text and return a slice of tokens. With WithCache, text
+// cut before returns the same slice as before.
 func (tk *Tokenizer) Cut(text string, useHmm bool) []string {
 	tk.pd.lock.RLock()
 	defer tk.pd.lock.RUnlock()
-	zhIndexes := zh.FindAllIndex([]byte(text), -1)
-	blocks := splitText(text, zhIndexes)
+	if tk.cache == nil {
+		return tk.cut(text, useHmm)
+	}
+	key := cutKey{text, useHmm}
+	if tokens, found := tk.cache.get(key); found {
+		return tokens
+	}
+	tokens := tk.cut(text, useHmm)
+	tk.cache.put(key, tokens)
+	return tokens
+}
+
+// Cut a slice of runes and return a slice of tokens. Output is
+// the same as Cut(string(runes), useHmm), without converting the
+// runes back and forth.
+func (tk *Tokenizer) CutRunes(runes []rune, useHmm bool) []string {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	if tk.forced != nil || (tk.Newlines != NewlineDrop && hasNewline(runes)) {
+		return tk.cut(string(runes), useHmm)
+	}
+	return tk.cutRunes(runes, useHmm, nil)
+}
+
+func hasNewline(runes []rune) bool {
+	for _, r := range runes {
+		if r == '\n' {
+			return true
+		}
+	}
+	return false
+}
+
+// Cut without locking the prefix dictionary. Callers must hold
+// tk.pd.lock.
+func (tk *Tokenizer) cut(text string, useHmm bool) []string {
+	if tk.Newlines == NewlineDrop || !strings.Contains(text, "\n") {
+		return tk.cutLine(text, useHmm)
+	}
+	result := []string{}
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 && tk.Newlines == NewlineKeep {
+			result = append(result, "\n")
+		}
+		result = append(result, tk.cutLine(line, useHmm)...)
+	}
+	return result
+}
 
+// Like cut, but ignore tk.Newlines.
+func (tk *Tokenizer) cutLine(text string, useHmm bool) []string {
+	if tk.forced == nil {
+		return tk.cutText(text, useHmm, nil)
+	}
+	// Forced segments replace their text; the text in between
+	// is cut as usual.
 	result := []string{}
-	for _, block := range blocks {
-		result = append(result, tk.cutBlock(block, useHmm)...)
+	matches := tk.forced.pattern.FindAllStringIndex(text, -1)
+	for _, block := range splitText(text, matches) {
+		if block.doProcess {
+			result = append(result, tk.chunkTokens(tk.forced.tokens[block.text])...)
+		} else {
+			result = append(result, tk.cutText(block.text, useHmm, nil)...)
+		}
+	}
+	return result
+}
+
+// Like cutLine, but skip forced segments, and record the words
+// that HMM cut in marks, unless it's nil.
+func (tk *Tokenizer) cutText(text string, useHmm bool, marks *hmmMarks) []string {
+	// Text without Han characters is a single non-Han block.
+	if !tk.containsHan(text) {
+		return tk.chunkTokens(tk.cutNonZh(text))
+	}
+	return tk.cutRunes([]rune(text), useHmm, marks)
+}
+
+// Rune spans of the words that HMM cut, recorded while cutting,
+// so that Tokenize can mark them FromHMM without cutting twice.
+type hmmMarks struct {
+	// Rune offset of the text being cut in the whole text.
+	offset int
+	spans  map[[2]int]bool
+}
+
+// Return marks for the part of the text that starts at rune pos
+// of the text being cut. Nil marks stay nil.
+func (m *hmmMarks) at(pos int) *hmmMarks {
+	if m == nil {
+		return nil
+	}
+	return &hmmMarks{m.offset + pos, m.spans}
+}
+
+// Record the tokens that HMM cut. The tokens start at rune start
+// of the text being cut, and fromHMM holds a flag for each.
+func (m *hmmMarks) add(start int, tokens []string, fromHMM []bool) {
+	if m == nil {
+		return
+	}
+	start += m.offset
+	for i, t := range tokens {
+		end := start + utf8.RuneCountInString(t)
+		if fromHMM[i] {
+			m.spans[[2]int{start, end}] = true
+		}
+		start = end
+	}
+}
+
+func (tk *Tokenizer) containsHan(text string) bool {
+	for _, r := range text {
+		if tk.HanRunes != nil {
+			if tk.HanRunes(r) {
+				return true
+			}
+		} else if r >= utf8.RuneSelf && unicode.Is(unicode.Han, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether r belongs in a Han block. See HanRunes.
+func (tk *Tokenizer) isHan(r rune) bool {
+	if tk.HanRunes != nil {
+		return tk.HanRunes(r)
+	}
+	return unicode.Is(unicode.Han, r)
+}
+
+// Return the byte ranges of the Han blocks of text, like
+// zh.FindAllStringIndex but as told by tk.isHan.
+func (tk *Tokenizer) hanIndexes(text string) [][]int {
+	if tk.HanRunes == nil {
+		return zh.FindAllStringIndex(text, -1)
+	}
+	indexes := [][]int{}
+	start := -1
+	for i, r := range text {
+		if tk.HanRunes(r) {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 {
+			indexes = append(indexes, []int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		indexes = append(indexes, []int{start, len(text)})
+	}
+	return indexes
+}
+
+func (tk *Tokenizer) cutRunes(runes []rune, useHmm bool, marks *hmmMarks) []string {
+	result := []string{}
+	tk.cutBlocks(runes, useHmm, marks, func(tokens []string) bool {
+		result = append(result, tokens...)
+		return true
+	})
+	return result
+}
+
+// Cut runes block by block, and call fn with the tokens of each
+// block. Stop early if fn returns false. Words that HMM cut are
+// recorded in marks, unless it's nil.
+func (tk *Tokenizer) cutBlocks(runes []rune, useHmm bool, marks *hmmMarks, fn func(tokens []string) bool) {
+	joiner := blockJoiner{tk: tk}
+	for _, block := range tk.splitBlocks(runes) {
+		blockRunes := runes[block.start:block.end]
+		var tokens []string
+		if block.doProcess {
+			var fromHMM []bool
+			tokens, fromHMM = tk.cutZh(blockRunes, useHmm)
+			marks.add(block.start, tokens, fromHMM)
+			if tk.MergeParticles != nil {
+				tokens = tk.mergeParticles(tokens)
+			}
+		} else {
+			tokens = tk.cutNonZh(string(blockRunes))
+		}
+		done := joiner.add(blockRunes, block.doProcess, tokens)
+		if len(done) != 0 && !fn(tk.chunkTokens(done)) {
+			return
+		}
+	}
+	if len(joiner.pending) != 0 {
+		fn(tk.chunkTokens(joiner.pending))
+	}
+}
+
+// Joins the tokens of consecutive blocks where MergeNumerals or
+// Units merge them across the blocks. Cut and CutParallel share
+// it, so that they join the same tokens.
+type blockJoiner struct {
+	tk *Tokenizer
+	// Runes of the previous block, if any.
+	prev []rune
+	// Tokens of the blocks added so far that aren't done: a
+	// numeral or measure word may still be merged into the last
+	// one.
+	pending []string
+}
+
+// Add the tokens of the next block, whose runes are `block`, and
+// return the tokens that are done, if any.
+func (j *blockJoiner) add(block []rune, han bool, tokens []string) []string {
+	prev := j.prev
+	j.prev = block
+	if j.tk.MergeNumerals && prev != nil {
+		tokens = mergeNumerals(prev, block, j.pending, tokens, han)
+		// The whole block joined the previous number, which may
+		// go on in the next block.
+		if len(tokens) == 0 {
+			return nil
+		}
+	}
+	if han && j.tk.Units != nil && prev != nil {
+		tokens = j.tk.mergeUnits(string(prev), j.pending, tokens)
+	}
+	done := j.pending
+	j.pending = tokens
+	return done
+}
+
+// Like add, for a block cut by a CutParallel worker. Without
+// MergeNumerals or Units, the tokens are done as they are.
+func (j *blockJoiner) addBlock(b textBlock, tokens []string) []string {
+	if !j.tk.joinsBlocks() {
+		return tokens
+	}
+	return j.add([]rune(b.text), b.doProcess, tokens)
+}
+
+// Report whether tokens are joined across blocks. See
+// blockJoiner.
+func (tk *Tokenizer) joinsBlocks() bool {
+	return tk.MergeNumerals || tk.Units != nil
+}
+
+// Merge the leading measure words of a Han block's `tokens` into
+// the number that ends the previous block. `prev` is the text of
+// the previous block and `result` holds the tokens cut so far.
+// The remaining tokens are returned.
+func (tk *Tokenizer) mergeUnits(prev string, result, tokens []string) []string {
+	last := len(result) - 1
+	if last < 0 || !number.MatchString(result[last]) {
+		return tokens
+	}
+	// The number must immediately precede the Han block.
+	if !strings.HasSuffix(prev, result[last]) {
+		return tokens
+	}
+	j := 0
+	for j < len(tokens) && tk.Units[tokens[j]] {
+		result[last] += tokens[j]
+		j++
+	}
+	return tokens[j:]
+}
+
+// Attach each token in tk.MergeParticles to the token before it.
+// The tokens of a Han block are contiguous, so the merged tokens
+// are still in the text.
+func (tk *Tokenizer) mergeParticles(tokens []string) []string {
+	merged := tokens[:0]
+	for i, token := range tokens {
+		if i > 0 && tk.MergeParticles[token] {
+			merged[len(merged)-1] += token
+		} else {
+			merged = append(merged, token)
+		}
+	}
+	return merged
+}
+
+// Chinese numeral characters that MergeNumerals joins with digits.
+const chineseNumerals = "〇零一二三四五六七八九十百千万亿"
+
+// Merge the leading numeral tokens of a block's `tokens` into the
+// number that ends the previous block, if one is made of digits
+// and the other of Chinese numerals, e.g. "2" and "千". `prev` and
+// `block` are the runes of the two blocks, and `result` holds the
+// tokens cut so far. The remaining tokens are returned. Only a
+// Han block's tokens are contiguous, so only there does merging
+// go on past the first token.
+func mergeNumerals(prev, block []rune, result, tokens []string, han bool) []string {
+	last := len(result) - 1
+	if last < 0 || len(tokens) == 0 || !isNumeral(result[last]) {
+		return tokens
+	}
+	// The number must end the previous block, and the first token
+	// must start this one; spaces are dropped from tokens.
+	lastRune, _ := utf8.DecodeLastRuneInString(result[last])
+	firstRune, _ := utf8.DecodeRuneInString(tokens[0])
+	if prev[len(prev)-1] != lastRune || block[0] != firstRune {
+		return tokens
+	}
+	j := 0
+	for j < len(tokens) && isNumeral(tokens[j]) && (j == 0 || han) {
+		result[last] += tokens[j]
+		j++
+	}
+	return tokens[j:]
+}
+
+// Report whether s is made of digits and Chinese numerals. Dots
+// and commas are allowed between them, as in 3.5万.
+func isNumeral(s string) bool {
+	isDigit := func(r rune) bool {
+		return '0' <= r && r <= '9' || strings.ContainsRune(chineseNumerals, r)
+	}
+	first, _ := utf8.DecodeRuneInString(s)
+	last, _ := utf8.DecodeLastRuneInString(s)
+	if !isDigit(first) || !isDigit(last) {
+		return false
+	}
+	for _, r := range s {
+		if !isDigit(r) && r != '.' && r != ',' {
+			return false
+		}
+	}
+	return true
+}
+
+// WordFreq is a token paired with its prefix dictionary frequency.
+type WordFreq struct {
+	Word string
+	Freq int
+}
+
+// Cut text and return each token with its frequency in the
+// prefix dictionary. Tokens not in the dictionary, such as
+// those found by HMM, have a frequency of 0.
+func (tk *Tokenizer) CutWithFreq(text string, useHmm bool) []WordFreq {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	words := tk.cut(text, useHmm)
+	result := make([]WordFreq, 0, len(words))
+	for _, w := range words {
+		result = append(result, WordFreq{w, tk.pd.termFreq[w]})
 	}
 	return result
 }
 
+// Cut text, then merge adjacent tokens from left to right: if
+// merge(a, b) returns true, a and b are replaced by the merged
+// token, which may in turn merge with the next one. merge is
+// called without the tokenizer's lock held.
+func (tk *Tokenizer) CutAndMerge(text string, useHmm bool, merge func(a, b string) (string, bool)) []string {
+	tokens := tk.Cut(text, useHmm)
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if n := len(result); n != 0 {
+			if merged, ok := merge(result[n-1], token); ok {
+				result[n-1] = merged
+				continue
+			}
+		}
+		result = append(result, token)
+	}
+	return result
+}
+
+// Cut text and return every run of n consecutive tokens, joined
+// with a space, e.g. the bigrams of a sentence for n = 2. Cut
+// drops spaces, so they can't be mistaken for part of a token.
+// Text with fewer than n tokens has no n-grams, and an empty
+// slice is returned; so is it for n below 1.
+func (tk *Tokenizer) CutNGrams(text string, useHmm bool, n int) []string {
+	tokens := tk.Cut(text, useHmm)
+	ngrams := []string{}
+	for i := 0; n > 0 && i+n <= len(tokens); i++ {
+		ngrams = append(ngrams, strings.Join(tokens[i:i+n], " "))
+	}
+	return ngrams
+}
+
+// Cut text and count the occurrences of each token.
+func (tk *Tokenizer) WordCounts(text string, useHmm bool) map[string]int {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	counts := map[string]int{}
+	tk.cutEach(text, useHmm, func(token string) bool {
+		counts[token]++
+		return true
+	})
+	return counts
+}
+
+// Posting is a term of a document in an inverted index.
+type Posting struct {
+	Term  string
+	DocID int
+	// Token ordinals, from 0, of each occurrence of Term.
+	Positions []int
+}
+
+// Cut a document and group its tokens into postings, one per
+// distinct token, in the order of their first occurrence. HMM is
+// used if it's available, as in ExtractTags.
+func (tk *Tokenizer) IndexTokens(docID int, text string) []Posting {
+	postings := []Posting{}
+	index := map[string]int{}
+	for i, token := range tk.Cut(text, tk.HasHMM()) {
+		k, found := index[token]
+		if !found {
+			k = len(postings)
+			index[token] = k
+			postings = append(postings, Posting{Term: token, DocID: docID})
+		}
+		postings[k].Positions = append(postings[k].Positions, i)
+	}
+	return postings
+}
+
+// Token is a word and its position in the source text.
+// Start and End are rune offsets; End is exclusive.
+type Token struct {
+	Word  string `json:"word"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	POS   string `json:"pos,omitempty"`
+	// The word is HMM's guess at a word that isn't in the
+	// dictionary, rather than a dictionary match.
+	FromHMM bool `json:"from_hmm,omitempty"`
+}
+
+// Cut text and return each token with its rune offsets in `text`.
+func (tk *Tokenizer) Tokenize(text string, useHmm bool) []Token {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	return tk.tokenize(text, useHmm)
+}
+
+func (tk *Tokenizer) tokenize(text string, useHmm bool) []Token {
+	var marks *hmmMarks
+	if useHmm {
+		marks = &hmmMarks{spans: map[[2]int]bool{}}
+	}
+	located := tk.locateTokens(text, useHmm, marks)
+	tokens := make([]Token, 0, len(located))
+	for _, t := range located {
+		if t.Start >= 0 {
+			t.POS = tk.wordPOS(t.Word)
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// Cut text and return the rune offsets of each token as
+// [start, end) pairs, so that token i is runes[r[i][0]:r[i][1]]
+// of runes := []rune(text). Unlike Cut, the ranges cover the
+// whole text in order: text that Cut skips, such as a run of
+// spaces, gets a range of its own.
+func (tk *Tokenizer) CutRanges(text string, useHmm bool) [][2]int {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	words := tk.cut(text, useHmm)
+	ranges := make([][2]int, 0, len(words)+1)
+	// Byte and rune offsets of the end of the last range.
+	offset, pos := 0, 0
+	for _, w := range words {
+		// Tokens follow one another in text, apart from text that
+		// Cut drops, so they're only searched for after a gap.
+		i := 0
+		if !strings.HasPrefix(text[offset:], w) {
+			i = strings.Index(text[offset:], w)
+			// A token that isn't in text, such as a forced
+			// segment's replacement, leaves its text to the gap.
+			if i < 0 {
+				continue
+			}
+			start := pos + utf8.RuneCountInString(text[offset:offset+i])
+			ranges = append(ranges, [2]int{pos, start})
+			pos = start
+		}
+		end := pos + utf8.RuneCountInString(w)
+		ranges = append(ranges, [2]int{pos, end})
+		offset += i + len(w)
+		pos = end
+	}
+	if n := pos + utf8.RuneCountInString(text[offset:]); pos < n {
+		ranges = append(ranges, [2]int{pos, n})
+	}
+	return ranges
+}
+
+// Cut text as cut does, and return each token with its
+// rune offsets in text. Tokens that aren't in text, such as
+// replacements from WithForcedSegments, have a Start and End of
+// -1. Tokens that marks recorded as HMM's are marked FromHMM.
+func (tk *Tokenizer) locateTokens(text string, useHmm bool, marks *hmmMarks) []Token {
+	if tk.Newlines == NewlineDrop || !strings.Contains(text, "\n") {
+		return tk.locateLine(nil, text, 0, useHmm, marks)
+	}
+	var tokens []Token
+	pos := 0
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 && tk.Newlines == NewlineKeep {
+			tokens = append(tokens, Token{Word: "\n", Start: pos - 1, End: pos})
+		}
+		tokens = tk.locateLine(tokens, line, pos, useHmm, marks)
+		pos += utf8.RuneCountInString(line) + 1
+	}
+	return tokens
+}
+
+// Like locateTokens, for a line that starts at rune pos of the
+// text, and append the tokens to `tokens`. Each forced segment
+// and the text in between are located on their own, so that a
+// segment's tokens never match text outside it.
+func (tk *Tokenizer) locateLine(tokens []Token, line string, pos int, useHmm bool, marks *hmmMarks) []Token {
+	if tk.forced == nil {
+		return locateWords(tokens, line, pos, tk.cutText(line, useHmm, marks.at(pos)), marks)
+	}
+	for _, block := range splitText(line, tk.forced.pattern.FindAllStringIndex(line, -1)) {
+		if block.doProcess {
+			tokens = locateForced(tokens, block.text, pos, tk.chunkTokens(tk.forced.tokens[block.text]))
+		} else {
+			tokens = locateWords(tokens, block.text, pos, tk.cutText(block.text, useHmm, marks.at(pos)), marks)
+		}
+		pos += utf8.RuneCountInString(block.text)
+	}
+	return tokens
+}
+
+// Find each of `words`, as cut from text, in text, which starts
+// at rune pos, and append them to `tokens`.
+func locateWords(tokens []Token, text string, pos int, words []string, marks *hmmMarks) []Token {
+	// Words appear in `text` in order, so each search resumes
+	// where the previous word ended.
+	byteOffset := 0
+	runeOffset := pos
+	for _, w := range words {
+		i := strings.Index(text[byteOffset:], w)
+		if i < 0 {
+			tokens = append(tokens, Token{Word: w, Start: -1, End: -1})
+			continue
+		}
+		runeOffset += utf8.RuneCountInString(text[byteOffset : byteOffset+i])
+		start := runeOffset
+		runeOffset += utf8.RuneCountInString(w)
+		byteOffset += i + len(w)
+		fromHMM := marks != nil && marks.spans[[2]int{start, runeOffset}]
+		tokens = append(tokens, Token{Word: w, Start: start, End: runeOffset, FromHMM: fromHMM})
+	}
+	return tokens
+}
+
+// Like locateWords, for the tokens of a forced segment. They may
+// be in any order, so each is found at its first place in the
+// segment that no earlier token took.
+func locateForced(tokens []Token, segment string, pos int, words []string) []Token {
+	taken := make([]bool, len(segment))
+	for _, w := range words {
+		start := -1
+		for from := 0; w != "" && from < len(segment); {
+			i := strings.Index(segment[from:], w)
+			if i < 0 {
+				break
+			}
+			i += from
+			if !anyTrue(taken[i : i+len(w)]) {
+				start = i
+				break
+			}
+			from = i + 1
+		}
+		if start < 0 {
+			tokens = append(tokens, Token{Word: w, Start: -1, End: -1})
+			continue
+		}
+		for k := start; k < start+len(w); k++ {
+			taken[k] = true
+		}
+		begin := pos + utf8.RuneCountInString(segment[:start])
+		tokens = append(tokens, Token{Word: w, Start: begin, End: begin + utf8.RuneCountInString(w)})
+	}
+	return tokens
+}
+
+func anyTrue(flags []bool) bool {
+	for _, f := range flags {
+		if f {
+			return true
+		}
+	}
+	return false
+}
+
+// RuneTag is a rune and its position in the token that holds
+// it: "B" (begin), "M" (middle), "E" (end) or "S" (single).
+type RuneTag struct {
+	Rune rune
+	Tag  string
+}
+
+// Cut text and tag each rune of each token with its BMES label,
+// the same states that HMM uses. Spaces that Cut drops are not
+// tagged.
+func (tk *Tokenizer) Tag(text string, useHmm bool) []RuneTag {
+	tags := []RuneTag{}
+	for _, w := range tk.Cut(text, useHmm) {
+		runes := []rune(w)
+		if len(runes) == 1 {
+			tags = append(tags, RuneTag{runes[0], "S"})
+			continue
+		}
+		for i, r := range runes {
+			tag := "M"
+			if i == 0 {
+				tag = "B"
+			} else if i == len(runes)-1 {
+				tag = "E"
+			}
+			tags = append(tags, RuneTag{r, tag})
+		}
+	}
+	return tags
+}
+
+// Return the tokens of the Han text in `text` that the prefix
+// dictionary doesn't cover: words found by HMM and single runes,
+// unless they're dictionary words with a frequency above 0.
+func (tk *Tokenizer) OOVTokens(text string) []Token {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	runes := []rune(text)
+	tokens := []Token{}
+	for _, block := range tk.splitRunes(runes) {
+		if !block.doProcess {
+			continue
+		}
+		start := block.start
+		tk.segmentZh(runes[block.start:block.end], true, func(word string, origin wordOrigin) {
+			end := start + utf8.RuneCountInString(word)
+			if freq, _ := tk.lookup(word); origin != originDAG && freq < 1 {
+				tokens = append(tokens, Token{Word: word, Start: start, End: end})
+			}
+			start = end
+		})
+	}
+	return tokens
+}
+
+// Cut text and return the tokens as a JSON array of
+// {"word", "start", "end", "pos"} objects. See Tokenize.
+func (tk *Tokenizer) CutJSON(text string, useHmm bool) ([]byte, error) {
+	return json.Marshal(tk.Tokenize(text, useHmm))
+}
+
+// A block of runes in [start, end). Han blocks are marked with
+// doProcess, as in textBlock.
+type runeBlock struct {
+	start     int
+	end       int
+	doProcess bool
+}
+
+// Split runes into alternating Han and non-Han blocks, as told
+// by tk.isHan. This is the rune equivalent of splitting with the
+// `zh` regexp.
+func (tk *Tokenizer) splitRunes(runes []rune) []runeBlock {
+	blocks := []runeBlock{}
+	for i := 0; i < len(runes); {
+		isHan := tk.isHan(runes[i])
+		j := i + 1
+		for j < len(runes) && tk.isHan(runes[j]) == isHan {
+			j++
+		}
+		blocks = append(blocks, runeBlock{i, j, isHan})
+		i = j
+	}
+	return blocks
+}
+
+// Split runes like splitRunes. If tk.MixedWords is set, ASCII
+// letters next to a Han block are moved into it, so that words
+// such as 江南style can be matched.
+func (tk *Tokenizer) splitBlocks(runes []rune) []runeBlock {
+	blocks := tk.splitRunes(runes)
+	if !tk.MixedWords {
+		return blocks
+	}
+	for k := range blocks {
+		if !blocks[k].doProcess {
+			continue
+		}
+		if k > 0 {
+			prev := &blocks[k-1]
+			for prev.start < prev.end && isASCIILetter(runes[prev.end-1]) {
+				prev.end--
+				blocks[k].start--
+			}
+		}
+		if k+1 < len(blocks) {
+			next := &blocks[k+1]
+			for next.start < next.end && isASCIILetter(runes[next.start]) {
+				next.start++
+				blocks[k].end++
+			}
+		}
+	}
+	// Drop the blocks that were emptied, and join the Han blocks
+	// they separated.
+	joined := []runeBlock{}
+	for _, b := range blocks {
+		last := len(joined) - 1
+		if b.start == b.end {
+			continue
+		} else if last >= 0 && joined[last].doProcess && b.doProcess {
+			joined[last].end = b.end
+		} else {
+			joined = append(joined, b)
+		}
+	}
+	return joined
+}
+
+func isASCIILetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// Return true if piece is made of ASCII letters only.
+func isLatin(piece string) bool {
+	for i := 0; i < len(piece); i++ {
+		if !isASCIILetter(rune(piece[i])) {
+			return false
+		}
+	}
+	return piece != ""
+}
+
+// Return true if textRunes[i:j] starts or ends inside a run of
+// ASCII letters.
+func splitsLatin(textRunes []rune, i, j int) bool {
+	if i > 0 && isASCIILetter(textRunes[i-1]) && isASCIILetter(textRunes[i]) {
+		return true
+	}
+	return j < len(textRunes) && isASCIILetter(textRunes[j-1]) && isASCIILetter(textRunes[j])
+}
+
+// Join adjacent pieces of ASCII letters, which a DAG cuts into
+// single letters unless they form a dictionary word.
+func joinLatin(pieces []string) []string {
+	joined := []string{}
+	for i, p := range pieces {
+		if i > 0 && isLatin(p) && isLatin(pieces[i-1]) {
+			joined[len(joined)-1] += p
+			continue
+		}
+		joined = append(joined, p)
+	}
+	return joined
+}
+
 // Identify the text index ranges to process.
 func splitText(text string, markedIndexes [][]int) []textBlock {
 	if len(markedIndexes) == 0 {
@@ -211,64 +1651,229 @@ func splitText(text string, markedIndexes [][]int) []textBlock {
 
 func (tk *Tokenizer) cutBlock(block textBlock, hmm bool) []string {
 	if block.doProcess {
-		return tk.cutZh(block.text, hmm)
+		tokens, _ := tk.cutZh([]rune(block.text), hmm)
+		if tk.MergeParticles != nil {
+			tokens = tk.mergeParticles(tokens)
+		}
+		return tokens
+	}
+	if tk.Newlines != NewlineKeep {
+		return tk.cutNonZh(block.text)
 	}
-	return tk.cutNonZh(block.text)
+	// Han blocks never hold a newline, so only non-Han blocks
+	// are cut line by line to keep them.
+	result := []string{}
+	for i, line := range strings.Split(block.text, "\n") {
+		if i > 0 {
+			result = append(result, "\n")
+		}
+		result = append(result, tk.cutNonZh(line)...)
+	}
+	return result
+}
+
+// Split tokens longer than tk.MaxTokenLen runes into chunks.
+func (tk *Tokenizer) chunkTokens(tokens []string) []string {
+	if tk.MaxTokenLen < 1 {
+		return tokens
+	}
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if utf8.RuneCountInString(token) <= tk.MaxTokenLen {
+			result = append(result, token)
+			continue
+		}
+		runes := []rune(token)
+		for start := 0; start < len(runes); start += tk.MaxTokenLen {
+			end := start + tk.MaxTokenLen
+			if end > len(runes) {
+				end = len(runes)
+			}
+			result = append(result, string(runes[start:end]))
+		}
+	}
+	return result
+}
+
+// cutZh `textRunes` using a prefix dictionary, and a Hidden Markov
+// model to identify and segment words. For each word, fromHMM
+// reports whether HMM cut it and it isn't a dictionary word.
+func (tk *Tokenizer) cutZh(textRunes []rune, hmm bool) (words []string, fromHMM []bool) {
+	// A single rune is its own word whatever the dictionary or
+	// HMM say, so skip building a DAG.
+	if len(textRunes) == 1 {
+		return []string{string(textRunes)}, []bool{false}
+	}
+	words = []string{}
+	fromHMM = []bool{}
+	tk.segmentZh(textRunes, hmm, func(word string, origin wordOrigin) {
+		guessed := false
+		if origin == originHMM {
+			freq, _ := tk.lookup(word)
+			guessed = freq < 1
+		}
+		words = append(words, word)
+		fromHMM = append(fromHMM, guessed)
+	})
+	return tk.collapseRepeats(words, fromHMM)
 }
 
-// cutZh `text` using a prefix dictionary, and a Hidden Markov
-// model to identify and segment words.
-func (tk *Tokenizer) cutZh(text string, hmm bool) []string {
-	dagPieces := tk.cutDAG(text)
-	if !hmm {
-		return dagPieces
+// Where a word from segmentZh comes from.
+type wordOrigin int
+
+const (
+	// A single rune left uncut, or a run of them grouped by
+	// GroupUnknown.
+	originRune wordOrigin = iota
+	// A multi-rune word matched in the prefix dictionary, or a
+	// run of letters.
+	originDAG
+	// A word cut by HMM from a run of single runes.
+	originHMM
+)
+
+// Segment `textRunes` and call fn with each word in order, and
+// where it comes from.
+func (tk *Tokenizer) segmentZh(textRunes []rune, hmm bool, fn func(word string, origin wordOrigin)) {
+	dagPieces := tk.cutDAG(textRunes)
+	if tk.MixedWords {
+		dagPieces = joinLatin(dagPieces)
+	}
+	if !hmm || !tk.HasHMM() {
+		unknown := []rune{}
+		for _, piece := range dagPieces {
+			multi := utf8.RuneCountInString(piece) > 1
+			if tk.GroupUnknown && !multi && !isLatin(piece) {
+				if freq, _ := tk.lookup(piece); freq < 1 {
+					unknown = append(unknown, []rune(piece)...)
+					continue
+				}
+			}
+			if len(unknown) != 0 {
+				fn(string(unknown), originRune)
+				unknown = nil
+			}
+			origin := originRune
+			if multi {
+				origin = originDAG
+			}
+			fn(piece, origin)
+		}
+		if len(unknown) != 0 {
+			fn(string(unknown), originRune)
+		}
+		return
 	}
 
 	// Use HMM to segment uncut chars in dagPieces.
-	words := []string{}
 	uncutRunes := []rune{}
+	flush := func() {
+		if len(uncutRunes) == 0 {
+			return
+		}
+		// Runs shorter than HMMMinLen stay single runes.
+		if len(uncutRunes) < tk.HMMMinLen {
+			for _, r := range uncutRunes {
+				fn(string(r), originRune)
+			}
+		} else {
+			v := tk.viterbi(string(uncutRunes))
+			for _, w := range tk.cutHMM(string(uncutRunes), v) {
+				fn(w, originHMM)
+			}
+		}
+		uncutRunes = nil
+	}
 	for i, piece := range dagPieces {
-		pieceRune := []rune(piece)
-		// Collect singletons for HMM segmentation
-		if len(pieceRune) == 1 {
-			uncutRunes = append(uncutRunes, pieceRune[0])
+		// Collect singletons for HMM segmentation. Letters are
+		// left to MixedWords' joinLatin.
+		if r, size := utf8.DecodeRuneInString(piece); size == len(piece) && !isLatin(piece) {
+			uncutRunes = append(uncutRunes, r)
 			// Run cutHMM at the end of iteration only if there
 			// are uncut runes.
-			if i+1 >= len(dagPieces) && len(uncutRunes) != 0 {
-				v := tk.hmm.viterbi(string(uncutRunes))
-				newWords := tk.cutHMM(string(uncutRunes), v)
-				words = append(words, newWords...)
-				uncutRunes = nil
+			if i+1 >= len(dagPieces) {
+				flush()
 			}
 		} else {
 			// Run cutHMM when a length > 1 rune is encountered.
-			if len(uncutRunes) != 0 {
-				v := tk.hmm.viterbi(string(uncutRunes))
-				newWords := tk.cutHMM(string(uncutRunes), v)
-				words = append(words, newWords...)
-				uncutRunes = nil
-			}
-			words = append(words, piece)
+			flush()
+			fn(piece, originDAG)
 		}
 	}
-	return words
 }
 
-// Cut `text` using a DAG path built from a prefix dictionary.
-func (tk *Tokenizer) cutDAG(text string) []string {
-	dag := tk.pd.buildDag(text)
-	dagProba := tk.pd.calcDagProba(text, dag)
-	dagPath := findDagPath(text, dagProba)
+// Cut text with HMM alone, ignoring the prefix dictionary. Each
+// Han block is segmented by the Viterbi algorithm as a whole.
+// Non-Han blocks are cut the same way as in Cut.
+func (tk *Tokenizer) CutHMMOnly(text string) []string {
+	runes := []rune(text)
+	result := []string{}
+	for _, block := range tk.splitRunes(runes) {
+		blockText := string(runes[block.start:block.end])
+		if block.doProcess {
+			words, _ := tk.collapseRepeats(tk.cutHMM(blockText, tk.viterbi(blockText)), nil)
+			result = append(result, words...)
+		} else {
+			result = append(result, tk.cutNonZh(blockText)...)
+		}
+	}
+	return result
+}
 
-	textRune := []rune(text)
-	pieces := []string{}
+// Cut text by forward maximum matching. It's the same as
+// CutLongest.
+func (tk *Tokenizer) CutMM(text string) []string {
+	return tk.CutLongest(text)
+}
+
+// Cut `textRunes` using a DAG path built from a prefix dictionary.
+func (tk *Tokenizer) cutDAG(textRunes []rune) []string {
+	// Look up the mapped runes, but cut the original ones.
+	lookupRunes := tk.mapRunes(textRunes)
+	var dagPath [][2]int
+	if tk.bigrams != nil {
+		dagPath = tk.bigramPath(lookupRunes, tk.buildDag(lookupRunes))
+	} else {
+		dagPath = tk.bestDagPath(lookupRunes)
+	}
+
+	// Slice the pieces out of one string, rather than allocate
+	// a string for each.
+	text := string(textRunes)
+	pieces := make([]string, 0, len(dagPath))
+	start := 0
 	for _, dagIndex := range dagPath {
-		p := string(textRune[dagIndex[0]:dagIndex[1]])
-		pieces = append(pieces, p)
+		end := start
+		for _, r := range textRunes[dagIndex[0]:dagIndex[1]] {
+			// Invalid runes are encoded as utf8.RuneError.
+			size := utf8.RuneLen(r)
+			if size < 0 {
+				size = utf8.RuneLen(utf8.RuneError)
+			}
+			end += size
+		}
+		pieces = append(pieces, text[start:end])
+		start = end
 	}
 	return pieces
 }
 
+// Return textRunes with tk.runeMap applied, or textRunes itself
+// if there's no map.
+func (tk *Tokenizer) mapRunes(textRunes []rune) []rune {
+	if tk.runeMap == nil {
+		return textRunes
+	}
+	mapped := make([]rune, len(textRunes))
+	for i, r := range textRunes {
+		if m, found := tk.runeMap[r]; found {
+			r = m
+		}
+		mapped[i] = r
+	}
+	return mapped
+}
+
 // Cut `text` according the the path found by the Viterbi algorithm.
 func (tk *Tokenizer) cutHMM(text string, viterbiPath []string) []string {
 	textRune := []rune(text)
@@ -281,32 +1886,165 @@ func (tk *Tokenizer) cutHMM(text string, viterbiPath []string) []string {
 			pieceStart = pieceEnd
 		}
 	}
-	return pieces
+	// Keep the runes after the last E or S, like jieba does.
+	if pieceStart < len(textRune) {
+		pieces = append(pieces, string(textRune[pieceStart:]))
+	}
+	return pieces
+}
+
+// Perform simple segmentation for space delimited alphanumeric
+// words. All other characters are broken into individual runes.
+// Optional patterns, such as URLs, are matched before alnum.
+func (tk *Tokenizer) cutNonZh(text string) []string {
+	if !tk.KeepPunctuation && !alnum.MatchString(text) {
+		return []string{}
+	}
+	patterns := []*regexp.Regexp{}
+	if tk.KeepURLs {
+		patterns = append(patterns, url, email)
+	}
+	if tk.KeepNumbers || tk.Units != nil {
+		patterns = append(patterns, numeral)
+	}
+	if tk.Pinyin {
+		patterns = append(patterns, pinyin)
+	}
+	if tk.KeepDiacritics && tk.SplitAlphaNum {
+		patterns = append(patterns, latinOrNum)
+	} else if tk.KeepDiacritics {
+		patterns = append(patterns, latinWord)
+	}
+	if tk.SplitAlphaNum {
+		patterns = append(patterns, alphaOrNum)
+	} else {
+		patterns = append(patterns, alnum)
+	}
+	textPieces := []string{}
+	if tk.keepChars == nil {
+		return tk.cutPatterns(textPieces, text, patterns)
+	}
+	start := 0
+	for i, r := range text {
+		if !tk.keepChars[r] {
+			continue
+		}
+		if start < i {
+			textPieces = tk.cutPatterns(textPieces, text[start:i], patterns)
+		}
+		textPieces = append(textPieces, string(r))
+		start = i + utf8.RuneLen(r)
+	}
+	if start < len(text) {
+		textPieces = tk.cutPatterns(textPieces, text[start:], patterns)
+	}
+	return textPieces
+}
+
+// Keep the matches of patterns[0] whole, and cut the text in
+// between them with the remaining patterns. Text that matches
+// no pattern is broken into individual runes, skipping spaces,
+// except that a run of the same rune is kept whole if
+// tk.CollapseRepeats is set, and so is a run within one of
+// tk.GroupCategories. The pieces are appended to textPieces.
+//
+// Matches are found one at a time, and pieces are slices of text
+// rather than copies, so the memory held is textPieces itself:
+// 16 bytes a piece. A 5MB run of "a+a+a+..." is 5 million pieces,
+// or 80MB; growing textPieces to that size allocates about 460MB
+// in all, most of it garbage by the time Cut returns. See
+// BenchmarkCutLongNonHan. Patterns must not match empty text.
+func (tk *Tokenizer) cutPatterns(textPieces []string, text string, patterns []*regexp.Regexp) []string {
+	if len(patterns) == 0 {
+		prev := ' '
+		prevGroup := -1
+		for i, r := range text {
+			if unicode.IsSpace(r) {
+				prev = r
+				prevGroup = -1
+				continue
+			}
+			group := tk.groupCategory(r)
+			if tk.CollapseRepeats && r == prev || group != -1 && group == prevGroup {
+				textPieces[len(textPieces)-1] += string(r)
+				prev = r
+				continue
+			}
+			textPieces = append(textPieces, runeAt(text, i, r))
+			prev = r
+			prevGroup = group
+		}
+		return textPieces
+	}
+	// Searching the rest of text after each match finds the same
+	// matches as FindAllStringIndex: a match ends where the next
+	// search starts, so a \b there holds in text as it does in
+	// the rest.
+	for text != "" {
+		loc := patterns[0].FindStringIndex(text)
+		if loc == nil {
+			break
+		}
+		if loc[0] > 0 {
+			textPieces = tk.cutPatterns(textPieces, text[:loc[0]], patterns[1:])
+		}
+		textPieces = append(textPieces, text[loc[0]:loc[1]])
+		text = text[loc[1]:]
+	}
+	if text != "" {
+		textPieces = tk.cutPatterns(textPieces, text, patterns[1:])
+	}
+	return textPieces
+}
+
+// Return the rune r at byte i of text as a slice of text, which
+// unlike string(r) doesn't allocate. An invalid byte is returned
+// as U+FFFD, like string(r).
+func runeAt(text string, i int, r rune) string {
+	if r == utf8.RuneError && !strings.HasPrefix(text[i:], string(utf8.RuneError)) {
+		return string(r)
+	}
+	return text[i : i+utf8.RuneLen(r)]
 }
 
-// Perform simple segmentation for space delimited alphanumeric
-// words. All other characters are broken into individual runes.
-func (tk *Tokenizer) cutNonZh(text string) []string {
-	alnumIdx := alnum.FindAllIndex([]byte(text), -1)
-	if len(alnumIdx) == 0 {
-		return []string{}
+// Return the index of the first of tk.GroupCategories that r
+// belongs to, or -1.
+func (tk *Tokenizer) groupCategory(r rune) int {
+	for i, table := range tk.GroupCategories {
+		if unicode.Is(table, r) {
+			return i
+		}
 	}
+	return -1
+}
 
-	textPieces := []string{}
-	blocks := splitText(text, alnumIdx)
-	for _, b := range blocks {
-		if b.doProcess {
-			textPieces = append(textPieces, b.text)
-		} else {
-			for _, r := range b.text {
-				if unicode.IsSpace(r) {
-					continue
-				}
-				textPieces = append(textPieces, string(r))
+// Merge adjacent identical single-rune tokens if
+// tk.CollapseRepeats is set. "哈", "哈", "哈" becomes "哈哈哈".
+// fromHMM, if not nil, holds a flag for each token, and is
+// returned with a flag for each merged token; a run of repeats
+// isn't HMM's.
+func (tk *Tokenizer) collapseRepeats(tokens []string, fromHMM []bool) ([]string, []bool) {
+	if !tk.CollapseRepeats {
+		return tokens, fromHMM
+	}
+	result := []string{}
+	var resultHMM []bool
+	prev := ""
+	for i, t := range tokens {
+		if utf8.RuneCountInString(t) == 1 && t == prev {
+			result[len(result)-1] += t
+			if fromHMM != nil {
+				resultHMM[len(resultHMM)-1] = false
 			}
+			continue
 		}
+		result = append(result, t)
+		if fromHMM != nil {
+			resultHMM = append(resultHMM, fromHMM[i])
+		}
+		prev = t
 	}
-	return textPieces
+	return result, resultHMM
 }
 
 /*Build a prefix dictionary from `dictionaryLines`.
@@ -339,7 +2077,8 @@ For example:
 */
 func (tk *Tokenizer) buildPrefixDictionary(dictionaryLines []string) error {
 	tk.pd.termFreq = make(map[string]int, len(dictionaryLines)*2)
-	total := 0
+	tk.pd.shared = false
+	total := int64(0)
 	for _, line := range dictionaryLines {
 		parts := strings.SplitN(line, " ", 3)
 		word := parts[0]
@@ -347,7 +2086,13 @@ func (tk *Tokenizer) buildPrefixDictionary(dictionaryLines []string) error {
 		if err != nil {
 			return err
 		}
-		total += count
+		// Lines may contain duplicates. Keep the first, like
+		// loadPrefixDictionaryFile, so both compute the same
+		// size. A 0 is a piece of an earlier word, not a word.
+		if val, found := tk.pd.termFreq[word]; found && val > 0 {
+			continue
+		}
+		total = addSize(total, count)
 		tk.pd.termFreq[word] = count
 
 		// Add word pieces.
@@ -368,79 +2113,366 @@ func (tk *Tokenizer) buildPrefixDictionary(dictionaryLines []string) error {
 // Add a word to the prefix dictionary.
 // If word already exists, the word's frequency value will
 // be updated. If freq is less than 1, a frequency will be
-// automatically calculated.
+// automatically calculated; see suggestFreq for when that is
+// enough to keep the word whole.
 func (tk *Tokenizer) AddWord(word string, freq int) {
 	if freq < 1 {
 		freq = tk.pd.suggestFreq(word, tk)
 	}
+	tk.updateDict(func() bool {
+		tk.pd.addTerm(word, freq)
+		return true
+	})
+}
+
+// Change the prefix dictionary with change, under the write lock,
+// then drop what was derived from the old one: the index of
+// folded terms and the cached Cut results. change returns false
+// if it changed nothing, and so does updateDict. change must call
+// pd.ownTermFreq before it writes to termFreq, unless it replaces
+// termFreq.
+func (tk *Tokenizer) updateDict(change func() bool) bool {
 	tk.pd.lock.Lock()
 	defer tk.pd.lock.Unlock()
-	tk.pd.addTerm(word, freq)
+	if !change() {
+		return false
+	}
+	tk.pd.folded = nil
+	tk.pd.foldOnce = sync.Once{}
+	tk.clearCache()
+	return true
+}
+
+// Add many words to the prefix dictionary at once, with their
+// frequencies, taking the write lock only once. Unlike AddWord,
+// it also adds each word's prefix pieces, so that the words are
+// matched without RebuildPrefixes. Frequencies below 1 are
+// suggested as in AddWord.
+func (tk *Tokenizer) AddWords(words map[string]int) {
+	// suggestFreq cuts text, which takes the lock.
+	suggested := map[string]int{}
+	for word, freq := range words {
+		if freq < 1 {
+			suggested[word] = tk.pd.suggestFreq(word, tk)
+		}
+	}
+	tk.updateDict(func() bool {
+		for word, freq := range words {
+			if freq < 1 {
+				freq = suggested[word]
+			}
+			tk.pd.insertTerm(word, freq)
+		}
+		return true
+	})
+}
+
+// Like AddWord, and tag word with the part-of-speech `pos`, such
+// as "n" or "i", which Tokenize reports in Token.POS.
+func (tk *Tokenizer) AddWordPOS(word string, freq int, pos string) {
+	if freq < 1 {
+		freq = tk.pd.suggestFreq(word, tk)
+	}
+	tk.updateDict(func() bool {
+		tk.pd.addTerm(word, freq)
+		if tk.pd.pos == nil {
+			tk.pd.pos = map[string]string{}
+		}
+		tk.pd.pos[word] = pos
+		return true
+	})
+}
+
+// Return the part-of-speech of word given to AddWordPOS, or
+// tk.DefaultPOS. Callers must hold tk.pd.lock.
+func (tk *Tokenizer) wordPOS(word string) string {
+	if pos, found := tk.pd.pos[word]; found {
+		return pos
+	}
+	return tk.DefaultPOS
+}
+
+// Add the missing prefix pieces of every word in the prefix
+// dictionary. A word is only matched if all its leading pieces
+// are present, which words added with AddWord may lack.
+func (tk *Tokenizer) RebuildPrefixes() {
+	tk.updateDict(func() bool {
+		tk.pd.ownTermFreq()
+		for term, freq := range tk.pd.termFreq {
+			// Pieces added during the loop have a frequency
+			// of 0, so it doesn't matter whether they are
+			// visited.
+			if freq > 0 {
+				tk.pd.addPieces(term)
+			}
+		}
+		return true
+	})
+}
+
+// Raise or lower the frequency of an existing word by delta.
+// The frequency never drops below 0. TuneFreq returns false,
+// and changes nothing, if word is not in the prefix dictionary.
+func (tk *Tokenizer) TuneFreq(word string, delta int) bool {
+	return tk.updateDict(func() bool {
+		return tk.pd.tuneTerm(word, delta)
+	})
+}
+
+// Reset the tokenizer to its freshly loaded state. The dictionary
+// is reloaded from its source, unless the file is unchanged since
+// it was last loaded, and all words added at runtime are
+// discarded.
+func (tk *Tokenizer) Reset() error {
+	var pd *prefixDictionary
+	var err error
+	switch {
+	case tk.built != nil:
+		pd = tk.built.share()
+	case tk.pd.source == jiebaGobFile:
+		pd, err = cachedJiebaPrefixDictionary()
+	default:
+		pd, err = cachedPrefixDictionary(tk.pd.source, loadBaseDictionaryFile)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tk.checkFreqOverflow(pd); err != nil {
+		return err
+	}
+	pd.prune(tk.minFreq)
+	tk.updateDict(func() bool {
+		tk.pd.termFreq = pd.termFreq
+		tk.pd.size = pd.size
+		tk.pd.shared = pd.shared
+		tk.pd.pos = nil
+		return true
+	})
+	return nil
 }
 
 type prefixDictionary struct {
 	termFreq map[string]int
-	size     int
+	size     int64
 	ready    bool
 	lock     sync.RWMutex
 	source   string
+	// termFreq is shared with other tokenizers that loaded the
+	// same file, and is copied before it's changed. See
+	// cachedPrefixDictionary.
+	shared bool
+	// Part-of-speech tags of words added with AddWordPOS.
+	pos map[string]string
+	// Lowercased terms, for case-insensitive lookups.
+	folded   map[string]string
+	foldOnce sync.Once
+	// The first frequency in the file that was out of range and
+	// clamped, if any, as an error. See WithFreqOverflow.
+	overflow error
+}
+
+// Dictionaries loaded from files, by absolute path. Tokenizers
+// that load the same, unchanged file share its termFreq, which is
+// never changed in place once shared; a tokenizer copies it on
+// its first change, e.g. by AddWord. There's one entry per path:
+// loading a file that has changed replaces its entry, so only
+// the latest version of each file is kept.
+var loadedDictionaries = struct {
+	sync.Mutex
+	entries map[string]loadedDictionary
+}{}
+
+type loadedDictionary struct {
+	size    int64
+	modTime time.Time
+	pd      *prefixDictionary
+}
+
+// Load the dictionary in filename with load, or return one that
+// shares termFreq with an earlier load of the same file if the
+// file hasn't changed since.
+func cachedPrefixDictionary(filename string, load func(string) (*prefixDictionary, error)) (*prefixDictionary, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		// Let load report the error.
+		return load(filename)
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return load(filename)
+	}
+	loadedDictionaries.Lock()
+	defer loadedDictionaries.Unlock()
+	entry, found := loadedDictionaries.entries[abs]
+	if found && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.pd.share(), nil
+	}
+	pd, err := load(filename)
+	if err != nil {
+		return nil, err
+	}
+	if loadedDictionaries.entries == nil {
+		loadedDictionaries.entries = map[string]loadedDictionary{}
+	}
+	loadedDictionaries.entries[abs] = loadedDictionary{info.Size(), info.ModTime(), pd}
+	return pd.share(), nil
+}
+
+// Return a new prefixDictionary that shares pd's termFreq.
+func (pd *prefixDictionary) share() *prefixDictionary {
+	return &prefixDictionary{
+		termFreq: pd.termFreq,
+		size:     pd.size,
+		ready:    pd.ready,
+		source:   pd.source,
+		shared:   true,
+		overflow: pd.overflow,
+	}
+}
+
+// Copy termFreq if it's shared, so that it can be changed.
+// Callers must hold pd.lock for writing, unless pd isn't in use
+// yet.
+func (pd *prefixDictionary) ownTermFreq() {
+	if !pd.shared {
+		return
+	}
+	termFreq := make(map[string]int, len(pd.termFreq))
+	for term, freq := range pd.termFreq {
+		termFreq[term] = freq
+	}
+	pd.termFreq = termFreq
+	pd.shared = false
 }
 
 func newPrefixDictionaryFromFile(filename string) *prefixDictionary {
-	pd := prefixDictionary{}
-	pd.lock.Lock()
-	defer pd.lock.Unlock()
+	pd, err := cachedPrefixDictionary(filename, loadBaseDictionaryFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return pd
+}
 
-	pd.source = filename
+// Load a dictionary file with one "word freq [pos]" entry per line.
+func loadPrefixDictionaryFile(filename string) (*prefixDictionary, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer file.Close()
 
 	// From file size, calculate how much map space to pre-allocate.
-	// Each line takes, on average, 14.5 bytes.
+	// Each line takes, on average, 14.5 bytes, and adds nearly
+	// one more entry for its pieces.
 	fileInfo, err := file.Stat()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	pd.termFreq = make(map[string]int, fileInfo.Size()/14)
-	// Scan and parse line by line.
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		parts := strings.SplitN(line, " ", 3)
-		word := parts[0]
-		count, err := strconv.Atoi(parts[1])
-		if err != nil {
-			log.Fatal(err)
+	return readPrefixDictionary(file, filename, int(fileInfo.Size()/8))
+}
+
+// Read a dictionary like loadPrefixDictionaryFile, from r. Errors
+// name the dictionary `filename`. The map is sized for capacity
+// entries.
+func readPrefixDictionary(r io.Reader, filename string, capacity int) (*prefixDictionary, error) {
+	pd := prefixDictionary{}
+	pd.lock.Lock()
+	defer pd.lock.Unlock()
+
+	pd.source = filename
+	pd.termFreq = make(map[string]int, capacity)
+	// Scan and parse line by line. Lines are parsed by hand
+	// because strings.SplitN and strconv.Atoi allocate.
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
 		}
-		// Source file may contain duplicates.
-		_, found := pd.termFreq[word]
-		if !found {
-			pd.termFreq[word] = count
-			pd.size += count
-		}
-		// // Add word fragments.
-		// wordR := []rune(word)
-		// piece := ""
-		// for _, char := range wordR[:len(wordR)-1] {
-		// 	piece += string(char)
-		// 	_, found := pd.termFreq[piece]
-		// 	if !found {
-		// 		pd.termFreq[piece] = 0
-		// 	}
-		// }
+		space := bytes.IndexByte(line, ' ')
+		if space < 1 {
+			return nil, fmt.Errorf("%s:%d: want \"word freq\", got %q", filename, lineNo, line)
+		}
+		count := 0
+		digits := line[space+1:]
+		if end := bytes.IndexByte(digits, ' '); end >= 0 {
+			digits = digits[:end]
+		}
+		overflow := false
+		for _, d := range digits {
+			if d < '0' || d > '9' {
+				return nil, fmt.Errorf("%s:%d: invalid frequency %q", filename, lineNo, digits)
+			}
+			if overflow || count > (math.MaxInt-int(d-'0'))/10 {
+				overflow = true
+				continue
+			}
+			count = count*10 + int(d-'0')
+		}
+		// Whether that's an error is up to the tokenizer. See
+		// WithFreqOverflow.
+		if overflow {
+			count = math.MaxInt
+			if pd.overflow == nil {
+				pd.overflow = fmt.Errorf("%s:%d: invalid frequency %q: %w", filename, lineNo, digits, strconv.ErrRange)
+			}
+		}
+		if len(digits) == 0 {
+			return nil, fmt.Errorf("%s:%d: missing frequency", filename, lineNo)
+		}
+		word := string(line[:space])
+		// Source file may contain duplicates. A 0 is a piece of
+		// an earlier word, not a word.
+		if val, found := pd.termFreq[word]; found && val > 0 {
+			continue
+		}
+		pd.termFreq[word] = count
+		pd.size = addSize(pd.size, count)
+		pd.addPieces(word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 	pd.ready = true
-	return &pd
+	return &pd, nil
+}
+
+// Like loadPrefixDictionaryFile, for the dictionary a tokenizer
+// is built on. It must have a word: without one to count, every
+// log probability would be -Inf.
+func loadBaseDictionaryFile(filename string) (*prefixDictionary, error) {
+	pd, err := loadPrefixDictionaryFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if pd.size < 1 {
+		return nil, fmt.Errorf("%s: %w", filename, ErrEmptyDictionary)
+	}
+	return pd, nil
 }
 
+const jiebaGobFile = "prefix_dictionary.gob"
+
 func newJiebaPrefixDictionary() *prefixDictionary {
-	// Load pre-built prefix dictionary from gob file.
-	gobFile, err := os.Open("prefix_dictionary.gob")
+	pd, err := cachedJiebaPrefixDictionary()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return pd
+}
+
+// Like loadJiebaPrefixDictionary, but shared. See
+// cachedPrefixDictionary.
+func cachedJiebaPrefixDictionary() (*prefixDictionary, error) {
+	return cachedPrefixDictionary(jiebaGobFile, loadJiebaPrefixDictionary)
+}
+
+// Load pre-built prefix dictionary from gob file, which is
+// jiebaGobFile outside of tests.
+func loadJiebaPrefixDictionary(filename string) (*prefixDictionary, error) {
+	gobFile, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("failed to open gob file: %v", err)
+		return nil, fmt.Errorf("failed to open gob file: %w", err)
 	}
 	defer gobFile.Close()
 
@@ -449,59 +2481,157 @@ func newJiebaPrefixDictionary() *prefixDictionary {
 	defer pd.lock.Unlock()
 	decoder := gob.NewDecoder(gobFile)
 	if err := decoder.Decode(&pd.termFreq); err != nil {
-		log.Fatalf("failed to decode pfDict from gobFile: %v", err)
+		return nil, fmt.Errorf("%w %s: %v", ErrDecode, filename, err)
+	}
+	if len(pd.termFreq) == 0 {
+		return nil, fmt.Errorf("%s: %w", filename, ErrEmptyDictionary)
 	}
 	pd.size = 60_101_967
 	pd.ready = true
-	pd.source = "prefix_dictionary.gob"
-	return &pd
-}
-
-// Build a DAG out of every rune:rune+N piece from text string.
-// The returned DAG's index values are based on []rune(text).
-func (pd *prefixDictionary) buildDag(text string) map[int][]int {
-	// Get the index of RUNES that are found in the prefix
-	// dictionary. If not found, save the rune slice as is.
-	textRunes := []rune(text)
-	pieces := [][2]int{}
-	for i, iRune := range textRunes {
-		count, found := pd.termFreq[string(iRune)]
-		if !found || count == 0 {
-			pieces = append(pieces, [2]int{i, i + 1})
-			continue
+	pd.source = jiebaGobFile
+	return &pd, nil
+}
+
+// Build a DAG out of every rune:rune+N piece from textRunes.
+// The returned DAG's index values are based on textRunes.
+// A rune that is only a prefix in the dictionary (frequency 0)
+// still starts the search for longer words, as in jieba's
+// get_DAG, so a word is found even if its first rune is not a
+// word on its own.
+func (tk *Tokenizer) buildDag(textRunes []rune) map[int][]int {
+	ws := dagWorkspaces.Get().(*dagWorkspace)
+	defer dagWorkspaces.Put(ws)
+	maxLen := tk.maxWordLen()
+	dag := make(map[int][]int, len(textRunes))
+	// All tails share one backing array. Most positions have
+	// one or two.
+	tails := make([]int, 0, len(textRunes))
+	for i := range textRunes {
+		tk.dagTails(textRunes, i, maxLen, ws)
+		start := len(tails)
+		tails = append(tails, ws.tails...)
+		dag[i] = tails[start:len(tails):len(tails)]
+	}
+	return dag
+}
+
+// Scratch buffers for finding a DAG path. They are reused across
+// calls through dagWorkspaces; each goroutine takes its own from
+// the pool, so concurrent Cuts never share one.
+type dagWorkspace struct {
+	// UTF-8 encoding of the piece being looked up.
+	key []byte
+	// Ends of the edges from one position, and their frequencies.
+	tails []int
+	freqs []int
+	// See bestDagPath.
+	bestProba []float64
+	next      []int
+	scores    []tailProba
+}
+
+var dagWorkspaces = sync.Pool{
+	New: func() interface{} { return &dagWorkspace{} },
+}
+
+// Set ws.tails to the DAG edges that start at textRunes[i], as
+// the indexes where they end, and ws.freqs to their frequencies.
+// An edge is a dictionary word, or the rune at i alone if no word
+// starts there. Under MixedWords, a word can't start or end inside
+// a run of letters, so that 江南style isn't matched in 江南styles.
+func (tk *Tokenizer) dagTails(textRunes []rune, i, maxLen int, ws *dagWorkspace) {
+	ws.key = ws.key[:0]
+	ws.tails = ws.tails[:0]
+	ws.freqs = ws.freqs[:0]
+	// Extend the piece for as long as it is a prefix in the
+	// dictionary, and keep the pieces that are real words.
+	for j := i + 1; j <= len(textRunes) && j-i <= maxLen; j++ {
+		ws.key = utf8.AppendRune(ws.key, textRunes[j-1])
+		count, found := tk.lookupKey(ws.key)
+		if !found {
+			break
 		}
-		for j := range textRunes[i:] {
-			part := textRunes[i : j+1+i]
-			val, found := pd.termFreq[string(part)]
-			if !found {
-				break
-			}
-			if val > 0 {
-				pieces = append(pieces, [2]int{i, j + 1 + i})
-			}
+		if count > 0 && !(tk.MixedWords && splitsLatin(textRunes, i, j)) {
+			ws.tails = append(ws.tails, j)
+			ws.freqs = append(ws.freqs, count)
 		}
 	}
-	// fmt.Println("pieces:", pieces)
+	if len(ws.tails) == 0 {
+		ws.tails = append(ws.tails, i+1)
+		ws.freqs = append(ws.freqs, 0)
+	}
+}
 
-	dag := make(map[int][]int, len(textRunes))
-	for _, p := range pieces {
-		val, found := dag[p[0]]
-		if !found {
-			dag[p[0]] = []int{p[1]}
-		} else {
-			dag[p[0]] = append(val, p[1])
+func (tk *Tokenizer) maxWordLen() int {
+	if tk.MaxWordLen < 1 {
+		return defaultMaxWordLen
+	}
+	return tk.MaxWordLen
+}
+
+// Look up a piece's frequency in the layers set by SetLayers,
+// then in the prefix dictionary. If tk.FoldCase is set and there's
+// no exact match, letters are matched case-insensitively in the
+// prefix dictionary.
+func (tk *Tokenizer) lookup(piece string) (int, bool) {
+	if tk.layers == nil {
+		return tk.lookupBase(piece)
+	}
+	// A word in a layer wins, but a piece of one is only a
+	// prefix if no lower layer has the word.
+	prefix := false
+	for _, layer := range tk.layers {
+		count, found := layer.termFreq[piece]
+		if count > 0 {
+			return count, true
 		}
+		prefix = prefix || found
 	}
-	// fmt.Println("dag:", d)
-	return dag
+	count, found := tk.lookupBase(piece)
+	return count, found || prefix
+}
+
+// Like lookup, but ignore tk.layers.
+func (tk *Tokenizer) lookupBase(piece string) (int, bool) {
+	count, found := tk.pd.termFreq[piece]
+	if found || !tk.FoldCase {
+		return count, found
+	}
+	key, found := tk.pd.foldedKeys()[strings.ToLower(piece)]
+	if !found {
+		return 0, false
+	}
+	return tk.pd.termFreq[key], true
+}
+
+// Like lookup, but an exact match doesn't allocate a string.
+func (tk *Tokenizer) lookupKey(key []byte) (int, bool) {
+	if tk.layers != nil {
+		return tk.lookup(string(key))
+	}
+	count, found := tk.pd.termFreq[string(key)]
+	if found || !tk.FoldCase {
+		return count, found
+	}
+	return tk.lookup(string(key))
+}
+
+// Return the log of the dictionary size, which every word's
+// log probability is relative to. A dictionary left without
+// words, e.g. by WithMinFreq or TuneFreq, counts as size 1, so
+// that probabilities stay finite.
+func (tk *Tokenizer) logSize() float64 {
+	if tk.pd.size < 1 {
+		return 0
+	}
+	return math.Log(float64(tk.pd.size))
 }
 
 // Calculate the log probability of each DAG path (piece),
-// and return the best path for each rune in `text`.
-// The return value's index are based on []rune(text).
-func (pd *prefixDictionary) calcDagProba(text string, dag map[int][]int) map[int][]tailProba {
-	total := math.Log(float64(pd.size))
-	textRunes := []rune(text)
+// and return the best path for each rune in `textRunes`.
+// The return value's index are based on textRunes.
+func (tk *Tokenizer) calcDagProba(textRunes []rune, dag map[int][]int) map[int][]tailProba {
+	total := tk.logSize()
 	dagProba := make(map[int][]tailProba, len(textRunes))
 
 	// Iterate through `textRunes` in reverse.
@@ -512,11 +2642,10 @@ func (pd *prefixDictionary) calcDagProba(text string, dag map[int][]int) map[int
 			// Calculate current piece's probability.
 			// piece_frequency = log(prefix_dictionary.get(piece) or 1.0) - total
 			// piece_proba = piece_frequency + next_piece_proba
-			tf := 1.0
-			if val, found := pd.termFreq[string(textRunes[i:j])]; found {
-				tf = float64(val)
-			}
-			pieceFreq := math.Log(tf) - total
+			// Prefix-only pieces have a frequency of 0. Like
+			// missing pieces, treat them as 1.0; log(0) is -Inf,
+			// which no path can outscore.
+			pieceFreq := tk.unigramScore(string(textRunes[i:j]), total)
 
 			// Get next piece's probability.
 			nextPiece := []tailProba{{j, 0.0}}
@@ -547,45 +2676,254 @@ func (pd *prefixDictionary) calcDagProba(text string, dag map[int][]int) map[int
 	return dagProba
 }
 
+// Score a piece by its frequency in the prefix dictionary, or
+// with tk.EdgeScorer if it's set. logSize is the log of the
+// dictionary size.
+func (tk *Tokenizer) unigramScore(piece string, logSize float64) float64 {
+	if tk.blocklist[piece] {
+		return minFloat
+	}
+	val, found := tk.lookup(piece)
+	if tk.EdgeScorer != nil {
+		return tk.EdgeScorer(piece, val, tk.pd.size) + tk.lengthScore(piece)
+	}
+	tf := 1.0
+	if found && val > 0 {
+		tf = float64(val)
+	}
+	return math.Log(tf) - logSize + tk.lengthScore(piece)
+}
+
+// Score the piece textRunes[i:j] like unigramScore, given its
+// frequency. The piece is only turned into a string for
+// tk.EdgeScorer or the blocklist.
+func (tk *Tokenizer) edgeScore(textRunes []rune, i, j, freq int, logSize float64) float64 {
+	if tk.EdgeScorer != nil || tk.blocklist != nil {
+		return tk.unigramScore(string(textRunes[i:j]), logSize)
+	}
+	tf := 1.0
+	if freq > 0 {
+		tf = float64(freq)
+	}
+	return math.Log(tf) - logSize + tk.lengthBonus*float64(j-i-1)
+}
+
+// Return the length bonus of a piece. See WithLengthBonus.
+func (tk *Tokenizer) lengthScore(piece string) float64 {
+	if tk.lengthBonus == 0 {
+		return 0
+	}
+	return tk.lengthBonus * float64(utf8.RuneCountInString(piece)-1)
+}
+
+// Find the same path as findDagPath(textRunes,
+// calcDagProba(textRunes, buildDag(textRunes))), without keeping
+// the whole DAG. Scoring runs from the end of the text, and an
+// edge is at most maxWordLen runes long, so only the best scores
+// of the last maxWordLen+1 positions are needed. This bounds
+// memory for long blocks.
+func (tk *Tokenizer) bestDagPath(textRunes []rune) [][2]int {
+	ws := dagWorkspaces.Get().(*dagWorkspace)
+	defer dagWorkspaces.Put(ws)
+	maxLen := tk.maxWordLen()
+	logSize := tk.logSize()
+	// Best log probability from position p to the end is kept
+	// in bestProba[p%len(bestProba)].
+	if cap(ws.bestProba) < maxLen+1 {
+		ws.bestProba = make([]float64, maxLen+1)
+	}
+	bestProba := ws.bestProba[:maxLen+1]
+	for p := range bestProba {
+		bestProba[p] = 0
+	}
+	// Where the best edge from each position ends.
+	if cap(ws.next) < len(textRunes) {
+		ws.next = make([]int, len(textRunes))
+	}
+	next := ws.next[:len(textRunes)]
+	for i := len(textRunes) - 1; i >= 0; i-- {
+		tk.dagTails(textRunes, i, maxLen, ws)
+		ws.scores = ws.scores[:0]
+		for k, j := range ws.tails {
+			proba := tk.edgeScore(textRunes, i, j, ws.freqs[k], logSize) + bestProba[j%len(bestProba)]
+			ws.scores = append(ws.scores, tailProba{j, proba})
+		}
+		best := maxIndexProba(ws.scores)
+		next[i] = best.index
+		bestProba[i%len(bestProba)] = best.proba
+	}
+
+	bestPath := [][2]int{}
+	for i := 0; i < len(textRunes); i = next[i] {
+		bestPath = append(bestPath, [2]int{i, next[i]})
+	}
+	return bestPath
+}
+
 // Find the path with the highest probability.
 // This is a helper method for calcDagProba().
-func findDagPath(text string, dagProba map[int][]tailProba) [][2]int {
-	textRunes := []rune(text)
-
+// buildDag gives every rune an edge, but if a DAG lacks one, so
+// that the best edge from i is missing or doesn't move forward,
+// the rune at i becomes a piece of its own and the walk goes on
+// from i+1, rather than cutting the path short.
+func findDagPath(textRunes []rune, dagProba map[int][]tailProba) [][2]int {
 	bestPath := [][2]int{}
-	for i := 0; i < len(textRunes) && i >= 0; {
-		tail := maxIndexProba(dagProba[i])
-		bestPath = append(bestPath, [2]int{i, tail.index})
-		i = tail.index
+	for i := 0; i < len(textRunes); {
+		next := maxIndexProba(dagProba[i]).index
+		if next <= i || next > len(textRunes) {
+			next = i + 1
+		}
+		bestPath = append(bestPath, [2]int{i, next})
+		i = next
 	}
 	return bestPath
 }
 
-// Return the item whose proba is the highest.
+// Return the item whose proba is the highest. Of items that tie,
+// the last one wins, so the longest word wins for tails in
+// ascending order. No items give an index of -1.
 func maxIndexProba(items []tailProba) tailProba {
-	prev := tailProba{-1, minFloat}
-	best := tailProba{-1, minFloat}
-	for _, item := range items {
-		if item.proba >= prev.proba {
+	if len(items) == 0 {
+		return tailProba{-1, minFloat}
+	}
+	best := items[0]
+	for _, item := range items[1:] {
+		if item.proba >= best.proba {
 			best = item
 		}
-		prev = item
-	}
-	if best.index == -1 {
-		return prev
 	}
 	return best
 }
 
+// Add delta to a dictionary size. The size is an int64 so that
+// it holds the sum of many int frequencies even where int is 32
+// bits; a sum beyond the int64 range stops at the limit instead
+// of wrapping around and turning negative.
+func addSize(size int64, delta int) int64 {
+	d := int64(delta)
+	if d > 0 && size > math.MaxInt64-d {
+		return math.MaxInt64
+	}
+	if d < 0 && size < math.MinInt64-d {
+		return math.MinInt64
+	}
+	return size + d
+}
+
+// Set the frequency of term. Callers must hold pd.lock and reset
+// pd.folded afterwards.
 func (pd *prefixDictionary) addTerm(term string, freq int) {
-	pd.lock.Lock()
-	defer pd.lock.Unlock()
+	pd.ownTermFreq()
+	pd.termFreq[term] = freq
+	pd.size = addSize(pd.size, freq)
+}
+
+// Set the frequency of term and add its prefix pieces. If term
+// is already present, its old frequency is taken off the size.
+// Callers must hold pd.lock and reset pd.folded afterwards.
+func (pd *prefixDictionary) insertTerm(term string, freq int) {
+	pd.ownTermFreq()
+	pd.size = addSize(addSize(pd.size, -pd.termFreq[term]), freq)
 	pd.termFreq[term] = freq
-	pd.size += freq
+	pd.addPieces(term)
+}
+
+// Add delta to the frequency of term, if it's present. Callers
+// must hold pd.lock and reset pd.folded afterwards.
+func (pd *prefixDictionary) tuneTerm(term string, delta int) bool {
+	freq, found := pd.termFreq[term]
+	if !found {
+		return false
+	}
+	if freq+delta < 0 {
+		delta = -freq
+	}
+	pd.ownTermFreq()
+	pd.termFreq[term] = freq + delta
+	pd.size = addSize(pd.size, delta)
+	return true
+}
+
+// Add the leading pieces of term, such as "AT" and "AT&" of
+// "AT&T", with a frequency of 0 unless they are already present.
+// If a piece is present, so are the shorter ones, so the pieces
+// are checked from the longest and the search stops there.
+func (pd *prefixDictionary) addPieces(term string) {
+	for end := len(term); end > 0; {
+		_, size := utf8.DecodeLastRuneInString(term[:end])
+		end -= size
+		if end == 0 {
+			return
+		}
+		if _, found := pd.termFreq[term[:end]]; found {
+			return
+		}
+		pd.ownTermFreq()
+		pd.termFreq[term[:end]] = 0
+	}
+}
+
+// Turn words with a frequency below minFreq into prefix-only
+// pieces, and remove their frequency from the size. They stay
+// in termFreq because longer words may start with them.
+func (pd *prefixDictionary) prune(minFreq int) {
+	if minFreq <= 1 {
+		return
+	}
+	pd.ownTermFreq()
+	for term, freq := range pd.termFreq {
+		if freq > 0 && freq < minFreq {
+			pd.termFreq[term] = 0
+			pd.size = addSize(pd.size, -freq)
+		}
+	}
+}
+
+// Return an index of lowercased terms to the terms in termFreq
+// that contain letters. It's built on first use.
+func (pd *prefixDictionary) foldedKeys() map[string]string {
+	pd.foldOnce.Do(func() {
+		pd.folded = map[string]string{}
+		for term := range pd.termFreq {
+			pd.foldKey(term)
+		}
+	})
+	return pd.folded
+}
+
+func (pd *prefixDictionary) foldKey(term string) {
+	lower := strings.ToLower(term)
+	if lower == term && strings.ToUpper(term) == term {
+		return
+	}
+	// Several terms may fold to the same key, e.g. "C#" and
+	// "c#". Keep the most frequent one.
+	if prev, found := pd.folded[lower]; found && pd.termFreq[prev] >= pd.termFreq[term] {
+		return
+	}
+	pd.folded[lower] = term
 }
 
+// Lowest frequency suggestFreq returns. A word that beats its own
+// pieces can still lose to a word that overlaps it, e.g. one that
+// starts inside it; a floor above the frequency of unknown runes
+// (1) makes that less likely for rare pieces.
+const minSuggestedFreq = 10
+
 // Calculate a frequency value based on current prefix
 // dictionary and its total size.
+//
+// The DAG path maximizes the sum of log(freq) - log(size) over a
+// path's words. If term is currently cut into pieces p1..pk, it
+// is cut whole instead once
+//
+//	freq(term) > freq(p1) * ... * freq(pk) / size^(k-1)
+//
+// which is the suggested value, plus one. This only compares term
+// against its own pieces; paths that cross term's edges in the
+// surrounding text are not considered. The product underflows to
+// 0 for long words of rare pieces, hence minSuggestedFreq. A term
+// that's already more frequent keeps its frequency.
 func (pd *prefixDictionary) suggestFreq(term string, tk *Tokenizer) int {
 	dSize := float64(pd.size)
 	if dSize < 1.0 {
@@ -602,6 +2940,9 @@ func (pd *prefixDictionary) suggestFreq(term string, tk *Tokenizer) int {
 	}
 
 	a := int(freq*dSize) + 1
+	if a < minSuggestedFreq {
+		a = minSuggestedFreq
+	}
 	b := 1
 	val, found := pd.termFreq[term]
 	if found {
@@ -626,6 +2967,66 @@ func newHMM(startProba map[string]float64, transitionProba, emitProba map[string
 
 // Load jieba's trained Hidden Markov model.
 func newJiebaHMM() hiddenMarkovModel {
+	hmm, err := loadJiebaHMM()
+	if err != nil {
+		panic(err.Error())
+	}
+	return hmm
+}
+
+// jieba's HMM never changes once loaded, so all tokenizers share
+// it. Only a successful load is cached.
+var jiebaHMM struct {
+	sync.Mutex
+	hmm    hiddenMarkovModel
+	loaded bool
+}
+
+// Emission probabilities are read from jiebaEmitGobFile if it
+// exists, which is faster than parsing jiebaEmitJSONFile.
+const (
+	jiebaEmitGobFile  = "prob_emit.gob"
+	jiebaEmitJSONFile = "prob_emit.json"
+)
+
+func loadJiebaHMM() (hiddenMarkovModel, error) {
+	jiebaHMM.Lock()
+	defer jiebaHMM.Unlock()
+	if jiebaHMM.loaded {
+		return jiebaHMM.hmm, nil
+	}
+	emitP, err := loadEmitProba(jiebaEmitGobFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		emitP, err = loadEmitProba(jiebaEmitJSONFile)
+	}
+	if err != nil {
+		return hiddenMarkovModel{}, err
+	}
+	jiebaHMM.hmm = buildJiebaHMM(emitP)
+	jiebaHMM.loaded = true
+	return jiebaHMM.hmm, nil
+}
+
+// Load emission probabilities from a gob file, if filename ends
+// with ".gob", or a JSON file.
+func loadEmitProba(filename string) (map[string]map[string]float64, error) {
+	emitP := map[string]map[string]float64{} // "B": {"word": -1.1, ...}, ...
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	if strings.HasSuffix(filename, ".gob") {
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&emitP)
+	} else {
+		err = json.Unmarshal(data, &emitP)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrDecode, filename, err)
+	}
+	return emitP, nil
+}
+
+func buildJiebaHMM(emitP map[string]map[string]float64) hiddenMarkovModel {
 	startP := map[string]float64{
 		"B": -0.26268660809250016,
 		"E": minFloat,
@@ -650,16 +3051,6 @@ func newJiebaHMM() hiddenMarkovModel {
 			"S": -0.6658631448798212, // S->S
 		},
 	}
-	emitP := map[string]map[string]float64{} // "B": {"word": -1.1, ...}, ...
-	jsonData, err := os.ReadFile("prob_emit.json")
-	if err != nil {
-		panic(fmt.Sprintf("failed to read prob_emit.json: %v", err))
-	}
-	err = json.Unmarshal(jsonData, &emitP)
-	if err != nil {
-		panic(fmt.Sprintf("failed to unmarshal json data: %v", err))
-	}
-
 	return newHMM(startP, transP, emitP)
 }
 
@@ -734,19 +3125,18 @@ func (hmm *hiddenMarkovModel) viterbi(text string) []string {
 // a S. This function finds the most likely route (E->B vs S->B)
 // along with the route's log probability.
 func (hmm *hiddenMarkovModel) stateTransitionRoute(step int, nowState string, hiddenStates map[int]map[string]float64) transitionRoute {
-	// List all possible routes and calculate their log probabilities.
-	routes := map[string]float64{}
+	// Pick the route with the highest log probability. Start
+	// from the first route rather than minFloat: with unknown
+	// runes every route can score below minFloat, and the path
+	// must still go on. Ties go to the greater state, like
+	// jieba's max() over (proba, state) tuples.
+	bestPrevState := ""
+	bestRouteProba := 0.0
 	for _, prevState := range stateChange[nowState] {
 		prevProb := hiddenStates[step-1][prevState]
-		routeProb := prevProb + hmm.transP[prevState][nowState]
-		routes[prevState] = routeProb
-	}
-
-	// Pick the route with the highest log probability.
-	bestPrevState := ""
-	bestRouteProba := minFloat
-	for prevState, routeProba := range routes {
-		if routeProba > bestRouteProba {
+		routeProba := prevProb + hmm.transP[prevState][nowState]
+		if bestPrevState == "" || routeProba > bestRouteProba ||
+			(routeProba == bestRouteProba && prevState > bestPrevState) {
 			bestPrevState = prevState
 			bestRouteProba = routeProba
 		}
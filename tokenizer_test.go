@@ -1,12 +1,23 @@
 package tokenizer
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode"
 )
 
 // const dictSize = 60_101_964
@@ -44,20 +55,1208 @@ func TestCut(t *testing.T) {
 		{"cut 7", "aaa\nbbb", []string{"aaa", "bbb"}, false},
 		{"cut 8", "这一刹那的撙近", []string{"这", "一刹那", "的", "撙", "近"}, false},
 		{"cut 9", "这一刹那的撙近", []string{"这", "一刹那", "的", "撙近"}, true},
-		{"cut 10", "撙", []string{"撙"}, false}, // This character causes memory leak.
+		{"cut 10", "撙", []string{"撙"}, false}, // Prefix-only character; see TestCutPrefixOnlyRune.
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
 			got := tk.Cut(c.text, c.hmm)
 			if !reflect.DeepEqual(c.want, got) {
-				t.Logf("tk.dag: %v", tk.dag)
-				t.Logf("tk.dagProba: %v", tk.dagProba)
+				t.Logf("lattice: %v", tk.BuildLattice(c.text))
 				t.Fatalf("%q wants %v, got %v", c.name, c.want, got)
 			}
 		})
 	}
 }
 
+// Run with -race to check that workers don't share state.
+func TestCutParallelRace(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天 50 n",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "今天天氣很好, abc 今天很好。今天天氣好 123 好"
+	want := tk.Cut(text, false)
+
+	done := make(chan []string)
+	for i := 0; i < 4; i++ {
+		go func() {
+			done <- tk.CutParallel(text, false, 4, true)
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		assertDeepEqual(t, want, <-done)
+	}
+}
+
+func TestCutParallelOrdered(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天 50 n",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("今天天氣很好, abc 今天很好。今天天氣好 123 好", 50)
+	want := tk.Cut(text, false)
+	for _, numWorkers := range []int{1, 4} {
+		got := []string{}
+		for token := range tk.CutParallelOrdered(context.Background(), text, false, numWorkers) {
+			got = append(got, token)
+		}
+		assertDeepEqual(t, want, got)
+	}
+	for range tk.CutParallelOrdered(context.Background(), "", false, 2) {
+		t.Fatal("want no tokens for empty text")
+	}
+}
+
+func TestCutParallelOrderedCancel(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"今天 100 t", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("今天好, ", 1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	tokens := tk.CutParallelOrdered(ctx, text, false, 4)
+	assertEqual(t, "今天", <-tokens)
+	// Abandon the channel. Once ctx is canceled, the read lock
+	// is released and the tokenizer can be changed.
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		tk.AddWord("天好", 10)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("AddWord is blocked after cancel")
+	}
+}
+
+// Options that join tokens must give the same tokens in parallel
+// as in Cut.
+func TestCutParallelOptions(t *testing.T) {
+	cases := []struct {
+		name string
+		set  func(tk *Tokenizer)
+	}{
+		{"Units", func(tk *Tokenizer) { tk.Units = DefaultUnits }},
+		{"MergeNumerals", func(tk *Tokenizer) { tk.MergeNumerals = true }},
+		{"MergeParticles", func(tk *Tokenizer) { tk.MergeParticles = map[string]bool{"的": true} }},
+	}
+	text := strings.Repeat("今天很好的3公斤2千, 3.5 公斤 第3千5百章", 20)
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tk := Tokenizer{MaxTokenLen: 4}
+			err := tk.buildPrefixDictionary([]string{
+				"今天 100 t",
+				"很 80 d",
+				"好 90 a",
+				"的 90 u",
+				"公斤 20 q",
+				"千 30 m",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.set(&tk)
+			want := tk.Cut(text, false)
+			assertDeepEqual(t, want, tk.CutParallel(text, false, 4, true))
+			// Unordered output may come in any order, unless
+			// the option joins blocks.
+			unordered := tk.CutParallel(text, false, 4, false)
+			sort.Strings(unordered)
+			sorted := append([]string{}, want...)
+			sort.Strings(sorted)
+			assertDeepEqual(t, sorted, unordered)
+			got := []string{}
+			for token := range tk.CutParallelOrdered(context.Background(), text, false, 4) {
+				got = append(got, token)
+			}
+			assertDeepEqual(t, want, got)
+		})
+	}
+}
+
+func TestCutParallelPanic(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"今天 100 t", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.cutBlockHook = func(b textBlock) {
+		if b.text == "壞" {
+			panic("bad block")
+		}
+	}
+
+	want := []string{"今天", "好", "好"}
+	got := tk.CutParallel("今天好,壞,好", false, 2, true)
+	assertDeepEqual(t, want, got)
+}
+
+func TestCutParallelSentences(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 100 t",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sentence punctuation isn't Han, so Han-only text is already
+	// split into one block per sentence, which are cut in
+	// parallel.
+	text := strings.Repeat("今天天氣很好。", 100)
+	blocks := make(chan string, 200)
+	tk.cutBlockHook = func(b textBlock) {
+		blocks <- b.text
+	}
+
+	assertDeepEqual(t, tk.Cut(text, false), tk.CutParallel(text, false, 4, true))
+	close(blocks)
+	han := 0
+	for block := range blocks {
+		if block == "今天天氣很好" {
+			han++
+		}
+	}
+	assertEqual(t, 100, han)
+}
+
+func TestIndexTokens(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 100 t",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Posting{
+		{"今天", 7, []int{0, 4}},
+		{"天氣", 7, []int{1}},
+		{"很", 7, []int{2, 5}},
+		{"好", 7, []int{3, 6}},
+	}
+	assertDeepEqual(t, want, tk.IndexTokens(7, "今天天氣很好 今天很好"))
+	assertDeepEqual(t, []Posting{}, tk.IndexTokens(7, ""))
+}
+
+func TestCutWithFreq(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []WordFreq{
+		{"今天", 100},
+		{"好", 90},
+		{"abc", 0},
+		{"撙", 0},
+	}
+	got := tk.CutWithFreq("今天好 abc 撙", false)
+	assertDeepEqual(t, want, got)
+}
+
+func TestCutUnits(t *testing.T) {
+	tk := Tokenizer{Units: DefaultUnits}
+	err := tk.buildPrefixDictionary([]string{
+		"万 100 m",
+		"元 100 m",
+		"公 50 n",
+		"公斤 20 q",
+		"买 30 v",
+		"了 300 ul",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"100万元", []string{"100万元"}},
+		{"买了3.5公斤", []string{"买", "了", "3.5公斤"}},
+		{"3.5 公斤", []string{"3.5", "公斤"}},
+		{"a3公斤", []string{"a3", "公斤"}},
+		{"100万买了", []string{"100万", "买", "了"}},
+		{"1,000元", []string{"1,000元"}},
+	}
+	for _, c := range cases {
+		got := tk.Cut(c.text, false)
+		if !reflect.DeepEqual(c.want, got) {
+			t.Errorf("case %q: want %v, got %v", c.text, c.want, got)
+		}
+	}
+
+	tk.Units = nil
+	assertDeepEqual(t, []string{"100", "万", "元"}, tk.Cut("100万元", false))
+}
+
+func TestCutRunes(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天 50 n",
+		"天氣 30 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{
+		"今天天氣好",
+		"abc 今天, 好 123好",
+		"",
+	} {
+		want := tk.Cut(text, false)
+		got := tk.CutRunes([]rune(text), false)
+		assertDeepEqual(t, want, got)
+	}
+	want := []string{"今天", "天氣", "ok", "好"}
+	assertDeepEqual(t, want, tk.CutRunes([]rune("今天天氣ok好"), false))
+}
+
+func TestCutWithoutHan(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{
+		"some english words",
+		"a1+1=2, ok?",
+		"번역『하다』ステーション",
+		"",
+	} {
+		want := tk.cutRunes([]rune(text), true, nil)
+		got := tk.Cut(text, true)
+		assertDeepEqual(t, want, got)
+	}
+	assertEqual(t, false, tk.containsHan("abc ステーション"))
+	assertEqual(t, true, tk.containsHan("abc 好"))
+}
+
+func TestSplitRunes(t *testing.T) {
+	tk := Tokenizer{}
+	cases := []struct {
+		text string
+		want []runeBlock
+	}{
+		{"xxx中文xxx", []runeBlock{{0, 3, false}, {3, 5, true}, {5, 8, false}}},
+		{"中文xxx", []runeBlock{{0, 2, true}, {2, 5, false}}},
+		{"xxx", []runeBlock{{0, 3, false}}},
+		{"中文", []runeBlock{{0, 2, true}}},
+		{"", []runeBlock{}},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			got := tk.splitRunes([]rune(c.text))
+			assertDeepEqual(t, c.want, got)
+		})
+	}
+}
+
+func TestHanRunes(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"東京 40 ns",
+		"食 30 v",
+		"食べる 50 v",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "東京で食べる"
+	// Keep the kana, which has no letters or digits.
+	tk.KeepPunctuation = true
+	// Kana is not Han, so 食べる isn't looked up even though the
+	// kana follows a kanji.
+	want := []string{"東京", "で", "食", "べ", "る"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	tk.HanRunes = func(r rune) bool { return unicode.Is(unicode.Han, r) }
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	assertDeepEqual(t, want, tk.CutParallel(text, false, 2, true))
+
+	tk.HanRunes = func(r rune) bool {
+		return unicode.In(r, unicode.Han, unicode.Hiragana)
+	}
+	want = []string{"東京", "で", "食べる"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	assertDeepEqual(t, want, tk.CutParallel(text, false, 2, true))
+	assertEqual(t, true, tk.containsHan("abc で"))
+}
+
+func TestCutAndMerge(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"在 80 p", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	isLetters := func(s string) bool {
+		for _, r := range s {
+			if !('A' <= r && r <= 'Z') {
+				return false
+			}
+		}
+		return s != ""
+	}
+	// Join single capital letters into acronyms.
+	acronyms := func(a, b string) (string, bool) {
+		if isLetters(a) && isLetters(b) && len(b) == 1 {
+			return a + b, true
+		}
+		return "", false
+	}
+	got := tk.CutAndMerge("在I B M好 X Y", false, acronyms)
+	assertDeepEqual(t, []string{"在", "IBM", "好", "XY"}, got)
+
+	never := func(a, b string) (string, bool) { return "", false }
+	assertDeepEqual(t, tk.Cut("在I B M好", false), tk.CutAndMerge("在I B M好", false, never))
+	assertDeepEqual(t, []string{}, tk.CutAndMerge("", false, acronyms))
+}
+
+func TestWordCounts(t *testing.T) {
+	tk := Tokenizer{Units: DefaultUnits}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"好 90 a",
+		"天 50 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		"今天": 3,
+		"好":  2,
+		"ok": 2,
+		"3天": 1,
+		",":  1,
+	}
+	got := tk.WordCounts("今天好 ok, 今天好ok 今天3天", false)
+	assertDeepEqual(t, want, got)
+}
+
+func TestWordCountsForced(t *testing.T) {
+	tk := Tokenizer{Newlines: NewlineKeep}
+	err := tk.buildPrefixDictionary([]string{
+		"北京 100 ns",
+		"北京大学 5000 nt",
+		"大学 80 n",
+		"大学生 30 n",
+		"在 90 p",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithForcedSegments(map[string][]string{"北京大学生": {"北京", "大学生"}})(&tk)
+	text := "在北京大学生\n北京大学生在北京大学"
+	want := map[string]int{}
+	for _, token := range tk.Cut(text, false) {
+		want[token]++
+	}
+	assertDeepEqual(t, map[string]int{"在": 2, "北京": 2, "大学生": 2, "北京大学": 1, "\n": 1}, want)
+	assertDeepEqual(t, want, tk.WordCounts(text, false))
+}
+
+func TestCutNGrams(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"我 50 r",
+		"昨天 40 t",
+		"去 30 v",
+		"上海 20 ns",
+		"玩 10 v",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "我昨天去上海玩"
+	want := []string{"我 昨天", "昨天 去", "去 上海", "上海 玩"}
+	assertDeepEqual(t, want, tk.CutNGrams(text, false, 2))
+	assertDeepEqual(t, []string{"我", "昨天", "去", "上海", "玩"}, tk.CutNGrams(text, false, 1))
+	assertDeepEqual(t, []string{"我 昨天 去 上海 玩"}, tk.CutNGrams(text, false, 5))
+	// Too few tokens, or a bad n.
+	assertDeepEqual(t, []string{}, tk.CutNGrams(text, false, 6))
+	assertDeepEqual(t, []string{}, tk.CutNGrams(text, false, 0))
+}
+
+func TestTokenize(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天 50 n",
+		"天氣 30 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{
+		{Word: "今天", Start: 0, End: 2},
+		{Word: "天氣", Start: 2, End: 4},
+		{Word: "very", Start: 5, End: 9},
+		{Word: "好", Start: 10, End: 11},
+	}
+	got := tk.Tokenize("今天天氣 very 好", false)
+	assertDeepEqual(t, want, got)
+}
+
+func TestTokenizeForced(t *testing.T) {
+	tk := Tokenizer{Newlines: NewlineKeep}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 100 t",
+		"天氣 30 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithForcedSegments(map[string][]string{
+		// 好 isn't in 很棒, so it mustn't be found further on.
+		"很棒": {"好"},
+		// Reordered tokens are found within their segment.
+		"天氣好": {"好", "天氣"},
+	})(&tk)
+	want := []Token{
+		{Word: "今天", Start: 2, End: 4},
+		{Word: "好", Start: 6, End: 7},
+		{Word: "天氣", Start: 4, End: 6},
+		{Word: "\n", Start: 7, End: 8},
+		{Word: "好", Start: 8, End: 9},
+	}
+	assertDeepEqual(t, want, tk.Tokenize("很棒今天天氣好\n好", false))
+}
+
+func TestTag(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 10 t",
+		"天氣 10 n",
+		"中華民國 5 ns",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RuneTag{
+		{'今', "B"}, {'天', "E"}, {'天', "B"}, {'氣', "E"},
+	}
+	assertDeepEqual(t, want, tk.Tag("今天天氣", false))
+	want = []RuneTag{
+		{'中', "B"}, {'華', "M"}, {'民', "M"}, {'國', "E"},
+		{'a', "B"}, {'b', "E"}, {'!', "S"},
+	}
+	assertDeepEqual(t, want, tk.Tag("中華民國 ab!", false))
+}
+
+func TestOOVTokens(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 10 t",
+		"我 20 r",
+		"叫 5 v",
+		"小 8 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.hmm = newTestHMM(map[string]map[string]float64{
+		"B": {"王": -1.0},
+		"M": {"小": -1.0},
+		"E": {"明": -1.0},
+		"S": {"我": -1.0, "叫": -1.0, "啊": -1.0},
+	})
+	// 王小明 is an invented name found by HMM, and 啊 is an
+	// unknown single rune. 我 and 叫 are dictionary words.
+	want := []Token{
+		{Word: "王小明", Start: 9, End: 12},
+		{Word: "啊", Start: 12, End: 13},
+	}
+	assertDeepEqual(t, want, tk.OOVTokens("hi, 今天 我叫王小明啊!"))
+	assertDeepEqual(t, []Token{}, tk.OOVTokens("今天我"))
+}
+
+func TestFromHMM(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 10 t",
+		"我 20 r",
+		"叫 5 v",
+		"小 8 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.hmm = newTestHMM(map[string]map[string]float64{
+		"B": {"王": -1.0},
+		"M": {"小": -1.0},
+		"E": {"明": -1.0},
+		"S": {"我": -1.0, "叫": -1.0, "啊": -1.0},
+	})
+	// HMM cuts the whole run 我叫王小明啊, but 我 and 叫 are
+	// dictionary words. 王小明 is an invented name.
+	text := "今天 我叫王小明啊!"
+	want := []Token{
+		{Word: "今天", Start: 0, End: 2},
+		{Word: "我", Start: 3, End: 4},
+		{Word: "叫", Start: 4, End: 5},
+		{Word: "王小明", Start: 5, End: 8, FromHMM: true},
+		{Word: "啊", Start: 8, End: 9, FromHMM: true},
+	}
+	assertDeepEqual(t, want, tk.Tokenize(text, true))
+	for _, token := range tk.Tokenize(text, false) {
+		assertEqual(t, false, token.FromHMM)
+	}
+	typed := []TypedToken{
+		{"今天", Han, false},
+		{"我", Han, false},
+		{"叫", Han, false},
+		{"王小明", Han, true},
+		{"啊", Han, true},
+	}
+	assertDeepEqual(t, typed, tk.CutTyped(text, true))
+
+	// Spans are recorded across lines too.
+	tk.Newlines = NewlineBreak
+	want = []Token{
+		{Word: "今天", Start: 0, End: 2},
+		{Word: "王小明", Start: 3, End: 6, FromHMM: true},
+	}
+	assertDeepEqual(t, want, tk.Tokenize("今天\n王小明", true))
+	tk.Newlines = NewlineDrop
+
+	// Forced segments aren't HMM's, even where it would cut the
+	// same word. That leaves 啊 alone, which HMM isn't needed for.
+	WithForcedSegments(map[string][]string{"王小明": {"王小明"}})(&tk)
+	assertEqual(t, false, tk.Tokenize(text, true)[3].FromHMM)
+	assertEqual(t, false, tk.Tokenize(text, true)[4].FromHMM)
+}
+
+func TestWithBlocklist(t *testing.T) {
+	tk := Tokenizer{}
+	WithBlocklist([]string{"上海"})(&tk)
+	err := tk.buildPrefixDictionary([]string{
+		"上 10 f",
+		"上海 500 ns",
+		"上海交通 20 ns",
+		"海 5 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []string{"上", "海", "好"}, tk.Cut("上海好", false))
+	assertDeepEqual(t, []string{"上", "海", "好"}, tk.CutMM("上海好"))
+	// Longer words that start with it are still found.
+	assertDeepEqual(t, []string{"上海交通", "好"}, tk.Cut("上海交通好", false))
+	// A blocked single rune is cut if there's no other way.
+	WithBlocklist([]string{"好"})(&tk)
+	assertDeepEqual(t, []string{"上海", "好"}, tk.Cut("上海好", false))
+}
+
+func TestCutMM(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"研究 100 vn",
+		"研究生 20 n",
+		"生命 80 n",
+		"命 5 n",
+		"起源 50 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "研究生命起源 ok，嗎"
+	want := []string{"研究", "生命", "起源", "ok", "，", "嗎"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	// The longest match at 0 is 研究生, which leaves 命 alone.
+	want = []string{"研究生", "命", "起源", "ok", "，", "嗎"}
+	assertDeepEqual(t, want, tk.CutMM(text))
+	assertDeepEqual(t, []string{}, tk.CutMM(""))
+}
+
+func TestNewlines(t *testing.T) {
+	tk := Tokenizer{}
+	WithForcedSegments(map[string][]string{"x\ny": {"x\ny"}})(&tk)
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		mode NewlineMode
+		want []string
+	}{
+		{NewlineDrop, []string{"aaa", "bbb", "x\ny", "好"}},
+		{NewlineKeep, []string{"aaa", "\n", "bbb", "\n", "x", "\n", "y", "好"}},
+		// The forced segment can't span the newline.
+		{NewlineBreak, []string{"aaa", "bbb", "x", "y", "好"}},
+	}
+	text := "aaa\nbbb\nx\ny好"
+	for _, c := range cases {
+		tk.Newlines = c.mode
+		assertDeepEqual(t, c.want, tk.Cut(text, false))
+		got := []string{}
+		tk.CutEach(text, false, func(token string) bool {
+			got = append(got, token)
+			return true
+		})
+		assertDeepEqual(t, c.want, got)
+		assertDeepEqual(t, c.want, tk.CutRunes([]rune(text), false))
+	}
+
+	// CutParallel doesn't apply forced segments.
+	tk.forced = nil
+	parallelCases := []struct {
+		mode NewlineMode
+		want []string
+	}{
+		{NewlineDrop, []string{"aaa", "bbb", "x", "y", "好", "好"}},
+		{NewlineKeep, []string{"aaa", "\n", "bbb", "\n", "x", "\n", "y", "好", "\n", "好"}},
+		{NewlineBreak, []string{"aaa", "bbb", "x", "y", "好", "好"}},
+	}
+	text += "\n好"
+	for _, c := range parallelCases {
+		tk.Newlines = c.mode
+		assertDeepEqual(t, c.want, tk.Cut(text, false))
+		assertDeepEqual(t, c.want, tk.CutRunes([]rune(text), false))
+		assertDeepEqual(t, c.want, tk.CutParallel(text, false, 2, true))
+	}
+	tk.Newlines = NewlineKeep
+	assertDeepEqual(t, []string{"\n", "\n"}, tk.Cut("\n\n", false))
+	assertDeepEqual(t, []string{"\n", "\n"}, tk.CutParallel("\n\n", false, 2, true))
+}
+
+func TestWithHMMMapping(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The HMM only knows the Simplified characters.
+	tk.hmm = newTestHMM(map[string]map[string]float64{
+		"B": {"张": -1.0},
+		"E": {"伟": -1.0},
+		"S": {"说": -1.0},
+	})
+	text := "張偉說好"
+	assertDeepEqual(t, []string{"張", "偉", "說", "好"}, tk.Cut(text, true))
+
+	WithHMMMapping(map[rune]rune{'張': '张', '偉': '伟', '說': '说'})(&tk)
+	assertDeepEqual(t, []string{"張偉", "說", "好"}, tk.Cut(text, true))
+}
+
+func TestCutHMMOnly(t *testing.T) {
+	tk := Tokenizer{}
+	// 王 and 說 are dictionary words, but HMM alone ignores them.
+	err := tk.buildPrefixDictionary([]string{"王 100 n", "說 100 v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.hmm = newTestHMM(map[string]map[string]float64{
+		"B": {"王": -1.0, "你": -1.0},
+		"M": {"小": -1.0},
+		"E": {"明": -1.0, "好": -1.0},
+		"S": {"說": -1.0},
+	})
+	want := []string{"王小明", "說", "hi", "，", "你好"}
+	assertDeepEqual(t, want, tk.CutHMMOnly("王小明說 hi，你好"))
+	assertDeepEqual(t, []string{"王", "小", "明", "說"}, tk.Cut("王小明說", false))
+}
+
+func TestMixedWords(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"江南 4986 ns",
+		"江南style 3 n",
+		"卡拉OK 10 n",
+		"B超 3 n",
+		"唱 50 v",
+		"你好 20 l",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.hmm = newTestHMM(map[string]map[string]float64{
+		"S": {"唱": -1.0, "去": -1.0},
+	})
+	text := "江南style, 唱卡拉OK去做B超 abc你好xyz"
+	want := []string{
+		"江南", "style", ",", "唱", "卡", "拉", "OK", "去", "做",
+		"B", "超", "abc", "你好", "xyz",
+	}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+
+	tk.MixedWords = true
+	want = []string{
+		"江南style", "唱", "卡拉OK", "去", "做",
+		"B超", "abc", "你好", "xyz",
+	}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	// A word doesn't match part of a longer run of letters.
+	want = []string{"江南", "styles", "卡拉OK", "123"}
+	assertDeepEqual(t, want, tk.Cut("江南styles卡拉OK123", false))
+	want = []string{"做", "xB", "超"}
+	assertDeepEqual(t, want, tk.Cut("做xB超", false))
+	want = []string{"唱", "卡拉OK", "去", "abc", "你好"}
+	assertDeepEqual(t, want, tk.Cut("唱卡拉OK去abc你好", true))
+}
+
+func TestRuneMapping(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"台 10 n",
+		"台湾 100 ns",
+		"大 50 a",
+		"大学 80 n",
+		"學 5 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "我在臺灣大學"
+	want := []string{"我", "在", "臺", "灣", "大", "學"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+
+	WithT2SMapping(map[rune]rune{'臺': '台', '灣': '湾', '學': '学'})(&tk)
+	want = []string{"我", "在", "臺灣", "大學"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	wantTokens := []Token{
+		{Word: "我", Start: 0, End: 1},
+		{Word: "在", Start: 1, End: 2},
+		{Word: "臺灣", Start: 2, End: 4},
+		{Word: "大學", Start: 4, End: 6},
+	}
+	assertDeepEqual(t, wantTokens, tk.Tokenize(text, false))
+
+	tk = Tokenizer{}
+	err = tk.buildPrefixDictionary([]string{"臺灣 100 ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithS2TMapping(map[rune]rune{'台': '臺', '湾': '灣'})(&tk)
+	assertDeepEqual(t, []string{"台湾"}, tk.Cut("台湾", false))
+}
+
+func TestHMMMinLen(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"我們 20 r", "喜歡 10 v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.hmm = newTestHMM(map[string]map[string]float64{
+		"B": {"小": -1.0, "大": -1.0},
+		"M": {"中": -1.0},
+		"E": {"明": -1.0, "華": -1.0},
+	})
+	text := "我們喜歡小明, 喜歡大中華"
+	want := []string{"我們", "喜歡", "小明", "喜歡", "大中華"}
+	assertDeepEqual(t, want, tk.Cut(text, true))
+	tk.HMMMinLen = 3
+	want = []string{"我們", "喜歡", "小", "明", "喜歡", "大中華"}
+	assertDeepEqual(t, want, tk.Cut(text, true))
+}
+
+func TestForcedSegments(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"北京 100 ns",
+		"北京大学 5000 nt",
+		"大学 80 n",
+		"大学生 30 n",
+		"生活 40 vn",
+		"活动 40 vn",
+		"在 90 p",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	WithForcedSegments(map[string][]string{
+		"北京大学生": {"北京", "大学生"},
+		"大学生活":  {"大学", "生活"},
+		"AB-12": {"AB", "-", "12"},
+	})(&tk)
+	cases := []struct {
+		text string
+		want []string
+	}{
+		// No override applies.
+		{"在北京大学", []string{"在", "北京大学"}},
+		// 北京大学生 starts before 大学生活.
+		{"北京大学生活动", []string{"北京", "大学生", "活动"}},
+		{"大学生活", []string{"大学", "生活"}},
+		{"在北京大学生, AB-12", []string{"在", "北京", "大学生", "AB", "-", "12"}},
+	}
+	for _, c := range cases {
+		assertDeepEqual(t, c.want, tk.Cut(c.text, false))
+		assertDeepEqual(t, c.want, tk.CutRunes([]rune(c.text), false))
+	}
+	WithForcedSegments(nil)(&tk)
+	assertDeepEqual(t, []string{"北京大学", "生活", "动"}, tk.Cut("北京大学生活动", false))
+}
+
+func TestGroupUnknown(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"我 20 r",
+		"喜歡 10 v",
+		"的 50 uj",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "我喜歡魑魅魍魎的囧"
+	want := []string{"我", "喜歡", "魑", "魅", "魍", "魎", "的", "囧"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	tk.GroupUnknown = true
+	want = []string{"我", "喜歡", "魑魅魍魎", "的", "囧"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+}
+
+func TestGroupCategories(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.KeepPunctuation = true
+	text := "好...好！！！a→→，b+-"
+	want := []string{"好", ".", ".", ".", "好", "！", "！", "！", "a", "→", "→", "，", "b", "+", "-"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+
+	tk.GroupCategories = []*unicode.RangeTable{unicode.P, unicode.S}
+	want = []string{"好", "...", "好", "！！！", "a", "→→", "，", "b", "+", "-"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	// Runs don't extend across spaces.
+	assertDeepEqual(t, []string{"..", "."}, tk.Cut(".. .", false))
+
+	// Only the listed categories are grouped.
+	tk.GroupCategories = []*unicode.RangeTable{unicode.Sm}
+	want = []string{"好", ".", ".", ".", "好", "！", "！", "！", "a", "→→", "，", "b", "+", "-"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+}
+
+func TestKeepDiacritics(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// café with a combining acute accent, and naïve precomposed.
+	text := "cafe\u0301好naïve"
+	want := []string{"cafe", "\u0301", "好", "na", "ï", "ve"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+
+	tk.KeepDiacritics = true
+	want = []string{"cafe\u0301", "好", "naïve"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	wantTokens := []Token{
+		{Word: "cafe\u0301", Start: 0, End: 5},
+		{Word: "好", Start: 5, End: 6},
+		{Word: "naïve", Start: 6, End: 11},
+	}
+	assertDeepEqual(t, wantTokens, tk.Tokenize(text, false))
+
+	tk.SplitAlphaNum = true
+	want = []string{"cafe\u0301", "2", "好", "naïve"}
+	assertDeepEqual(t, want, tk.Cut("cafe\u03012好naïve", false))
+}
+
+func TestSplitAlphaNum(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		text  string
+		whole []string
+		split []string
+	}{
+		{"abc123", []string{"abc123"}, []string{"abc", "123"}},
+		{"a1b2", []string{"a1b2"}, []string{"a", "1", "b", "2"}},
+		{"好abc 3.5", []string{"好", "abc", "3", ".", "5"}, []string{"好", "abc", "3", ".", "5"}},
+	}
+	for _, c := range cases {
+		tk.SplitAlphaNum = false
+		assertDeepEqual(t, c.whole, tk.Cut(c.text, false))
+		tk.SplitAlphaNum = true
+		assertDeepEqual(t, c.split, tk.Cut(c.text, false))
+	}
+	tk.KeepNumbers = true
+	assertDeepEqual(t, []string{"v", "3.5", "x", "2"}, tk.Cut("v 3.5 x2", false))
+}
+
+func TestWithKeepChars(t *testing.T) {
+	tk := Tokenizer{}
+	WithKeepChars([]rune{'/', '-', ' '})(&tk)
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []string{"a", "/", "b", "-", "c"}, tk.Cut("a/b-c", false))
+
+	tk.KeepURLs = true
+	tk.CollapseRepeats = true
+	// The URL is split at the kept runes before it's matched.
+	text := "http://x.com/a--b 好"
+	want := []string{"http", ":", "/", "/", "x", ".", "com", "/", "a", "-", "-", "b", " ", "好"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+}
+
+func TestMaxTokenLen(t *testing.T) {
+	tk := Tokenizer{MaxTokenLen: 4}
+	err := tk.buildPrefixDictionary([]string{
+		"中华人民共和国 50 ns",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "supercalifragilistic 中华人民共和国好"
+	want := []string{"supe", "rcal", "ifra", "gili", "stic", "中华人民", "共和国", "好"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	assertDeepEqual(t, want, tk.CutParallel(text, false, 2, true))
+	assertDeepEqual(t, []string{"abcd", "e"}, tk.Cut("abcde", false))
+
+	// Forced segments are chunked too.
+	WithForcedSegments(map[string][]string{"好好学习天天向上": {"好好学习天天向上"}})(&tk)
+	want = []string{"好好学习", "天天向上", "好"}
+	assertDeepEqual(t, want, tk.Cut("好好学习天天向上好", false))
+	got := []string{}
+	tk.CutEach("好好学习天天向上好", false, func(token string) bool {
+		got = append(got, token)
+		return true
+	})
+	assertDeepEqual(t, want, got)
+	assertDeepEqual(t, []string{"好好学习天天向上"}, tk.forced.tokens["好好学习天天向上"])
+
+	tk.MaxTokenLen = 0
+	assertDeepEqual(t, []string{"supercalifragilistic"}, tk.Cut("supercalifragilistic", false))
+}
+
+func TestCutRanges(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 100 t",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "  今天天氣很好, hello  world!\n"
+	got := tk.CutRanges(text, false)
+	want := [][2]int{{0, 2}, {2, 4}, {4, 6}, {6, 7}, {7, 8}, {8, 9}, {9, 10}, {10, 15}, {15, 17}, {17, 22}, {22, 23}, {23, 24}}
+	assertDeepEqual(t, want, got)
+
+	// The ranges tile the text, and the tokens of Cut are among
+	// them.
+	runes := []rune(text)
+	pos := 0
+	pieces := []string{}
+	for _, r := range got {
+		if r[0] != pos || r[1] <= r[0] {
+			t.Fatalf("want a range from %d, got %v", pos, r)
+		}
+		pos = r[1]
+		if piece := string(runes[r[0]:r[1]]); strings.TrimSpace(piece) != "" {
+			pieces = append(pieces, piece)
+		}
+	}
+	assertEqual(t, len(runes), pos)
+	assertDeepEqual(t, tk.Cut(text, false), pieces)
+	assertDeepEqual(t, [][2]int{}, tk.CutRanges("", false))
+
+	// The text of a forced segment that isn't in the text gets a
+	// range of its own.
+	WithForcedSegments(map[string][]string{"天氣很": {"天气很"}})(&tk)
+	want = [][2]int{{0, 2}, {2, 5}, {5, 6}}
+	assertDeepEqual(t, want, tk.CutRanges("今天天氣很好", false))
+}
+
+func TestCutLongNonHan(t *testing.T) {
+	tk := Tokenizer{}
+	if err := tk.buildPrefixDictionary([]string{"好 90 a"}); err != nil {
+		t.Fatal(err)
+	}
+	text := strings.Repeat("a+", 500_000)
+	tokens := tk.Cut(text, false)
+	assertEqual(t, len(text), len(tokens))
+	assertDeepEqual(t, []string{"a", "+", "a", "+"}, tokens[:4])
+	assertEqual(t, text, strings.Join(tokens, ""))
+	// One allocation per regexp match, plus growing the result.
+	allocs := testing.AllocsPerRun(1, func() { tk.Cut(text, false) })
+	if allocs > 500_000+100 && !raceEnabled {
+		t.Errorf("want at most %d allocations, got %.0f", 500_000+100, allocs)
+	}
+
+	// Invalid bytes are still cut as U+FFFD.
+	assertDeepEqual(t, []string{"a", "\ufffd", "+"}, tk.Cut("a\xff+", false))
+}
+
+func TestMergeParticles(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"我 50 r",
+		"喜欢 40 v",
+		"红色 30 n",
+		"的 90 u",
+		"花 20 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "我喜欢红色的花，的"
+	want := []string{"我", "喜欢", "红色", "的", "花", "的"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+
+	tk.MergeParticles = map[string]bool{"的": true}
+	want = []string{"我", "喜欢", "红色的", "花", "的"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	tokens := tk.Tokenize(text, false)
+	assertDeepEqual(t, Token{Word: "红色的", Start: 3, End: 6}, tokens[2])
+	assertDeepEqual(t, Token{Word: "花", Start: 6, End: 7}, tokens[3])
+	assertDeepEqual(t, Token{Word: "的", Start: 8, End: 9}, tokens[4])
+}
+
+func TestMergeNumerals(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"第 50 m",
+		"章 30 n",
+		"人 80 n",
+		"千米 20 q",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []string{"2", "千"}, tk.Cut("2千", false))
+
+	tk.MergeNumerals = true
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"2千", []string{"2千"}},
+		{"第3章", []string{"第", "3", "章"}},
+		{"3千5百人", []string{"3千5百", "人"}},
+		{"五3", []string{"五3"}},
+		{"2千米", []string{"2", "千米"}},
+		{"2 千", []string{"2", "千"}},
+		{"千 5 6", []string{"千", "5", "6"}},
+		{"一2 3", []string{"一2", "3"}},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			assertDeepEqual(t, c.want, tk.Cut(c.text, false))
+		})
+	}
+}
+
+func TestPinyin(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []string{"w", "ǒ", "h", "ě", "n", "h", "ǎ", "o"}, tk.Cut("wǒ hěn hǎo", false))
+
+	tk.Pinyin = true
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"wo3 hen3 hao3", []string{"wo3", "hen3", "hao3"}},
+		{"wǒ hěn hǎo", []string{"wǒ", "hěn", "hǎo"}},
+		{"nǚ'ér, lüe4", []string{"nǚ", "'", "ér", ",", "lüe4"}},
+		{"好(hǎo)", []string{"好", "(", "hǎo", ")"}},
+		{"hello123", []string{"hello123"}},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			assertDeepEqual(t, c.want, tk.Cut(c.text, false))
+		})
+	}
+}
+
+func TestCutJSON(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tk.CutJSON("今天 好", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"word":"今天","start":0,"end":2},{"word":"好","start":3,"end":4}]`
+	assertEqual(t, want, string(got))
+}
+
+// "撙" is only a prefix in the dictionary, with a count of 0. Its
+// log probability used to be log(0) = -Inf, which maxIndexProba
+// could not rank, so the best path walk could run away.
+func TestCutPrefixOnlyRune(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"撙节 10 v",
+		"近 50 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	textRunes := []rune("撙近")
+	dagProba := tk.calcDagProba(textRunes, tk.buildDag(textRunes))
+	for i, tails := range dagProba {
+		for _, tail := range tails {
+			if math.IsInf(tail.proba, 0) || math.IsNaN(tail.proba) {
+				t.Errorf("dagProba[%d] has invalid proba %v", i, tail.proba)
+			}
+		}
+	}
+	assertDeepEqual(t, []string{"撙"}, tk.Cut("撙", false))
+	assertDeepEqual(t, []string{"撙", "近"}, tk.Cut("撙近", false))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		tk.Cut("撙", false)
+	})
+	if allocs > 30 {
+		t.Errorf("want at most 30 allocs for a single rune, got %v", allocs)
+	}
+}
+
 func TestSplitText(t *testing.T) {
 	cases := []struct {
 		text string
@@ -70,67 +1269,270 @@ func TestSplitText(t *testing.T) {
 		{"中文", []textBlock{{0, "中文", true}}},
 		{"english번역『하다』今天天氣很好，ステーション1+1=2我昨天去上海*important*去", []textBlock{{0, "english번역『하다』", false}, {1, "今天天氣很好", true}, {2, "，ステーション1+1=2", false}, {3, "我昨天去上海", true}, {4, "*important*", false}, {5, "去", true}}},
 	}
-	for _, c := range cases {
-		t.Run(c.text, func(t *testing.T) {
-			zhIndexes := zh.FindAllIndex([]byte(c.text), -1)
-			got := splitText(c.text, zhIndexes)
-			assertDeepEqual(t, c.want, got)
-		})
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			zhIndexes := zh.FindAllIndex([]byte(c.text), -1)
+			got := splitText(c.text, zhIndexes)
+			assertDeepEqual(t, c.want, got)
+		})
+	}
+}
+
+func TestBuildDAG(t *testing.T) {
+	tk := NewJiebaTokenizer()
+	cases := []struct {
+		text string
+		want map[int][]int
+	}{
+		{"今天天氣很好", map[int][]int{
+			0: {1, 2}, // text[0:1], text[0:2] == 今, 今天
+			1: {2, 3}, // text[1:2], text[1:3] == 天, 天天
+			2: {3},    // text[2:3] == 天
+			3: {4},    // text[3:4] == 氣
+			4: {5},    // text[4:5] == 很
+			5: {6},    // text[5:6] == 好
+		}},
+		{"我昨天去上海交通大學與老師討論量子力學", map[int][]int{
+			0:  {1},
+			1:  {2, 3}, // 昨 昨天
+			2:  {3},
+			3:  {4},
+			4:  {5, 6}, // 上 上海
+			5:  {6},
+			6:  {7, 8}, // 交 交通
+			7:  {8},
+			8:  {9},
+			9:  {10},
+			10: {11},
+			11: {12},
+			12: {13},
+			13: {14},
+			14: {15},
+			15: {16, 17}, // 量 量子
+			16: {17, 18}, // 子 子力
+			17: {18},
+			18: {19},
+		}},
+		{"这一刹那的撙近", map[int][]int{
+			0: {1},
+			1: {2, 3, 4}, // 一 一刹 一刹那
+			2: {3, 4},    // 刹 刹那
+			3: {4},
+			4: {5},
+			5: {6},
+			6: {7},
+		}},
+		{"撙", map[int][]int{0: {1}}}, // "撙" is in prefix dict; has count == 0.
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			got := tk.buildDag([]rune(c.text))
+			assertDeepEqual(t, c.want, got)
+		})
+	}
+}
+
+func TestBuildDAGPrefixOnlyStart(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"AT&T 3 nz",
+		"江南 4986 ns",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "A" and "江" are prefix-only, but words start with them.
+	want := map[int][]int{0: {4}, 1: {2}, 2: {3}, 3: {4}}
+	assertDeepEqual(t, want, tk.buildDag([]rune("AT&T")))
+	want = map[int][]int{0: {2}, 1: {2}}
+	assertDeepEqual(t, want, tk.buildDag([]rune("江南")))
+}
+
+// A word whose first rune is not a word on its own is reachable,
+// so Cut chooses it over single runes.
+func TestCutPrefixOnlyStart(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"上海交通大學 10 nt",
+		"與 20 p",
+		"老師 30 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"上海交通大學", "與", "老師"}
+	assertDeepEqual(t, want, tk.Cut("上海交通大學與老師", false))
+}
+
+func TestFoldCase(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"AT&T 3 nz",
+		"c# 3 nz",
+		"C# 5",
+		"江南style 3 n",
+		"江南 4986 ns",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		piece    string
+		foldCase bool
+		want     int
+		found    bool
+	}{
+		{"AT&T", false, 3, true},
+		{"at&t", false, 0, false},
+		{"at&t", true, 3, true},
+		{"At&T", true, 3, true},
+		{"at&", true, 0, true}, // prefix
+		{"c#", true, 3, true},  // exact match first
+		{"江南STYLE", true, 3, true},
+		{"江北", true, 0, false},
+	}
+	for _, c := range cases {
+		tk.FoldCase = c.foldCase
+		got, found := tk.lookup(c.piece)
+		if got != c.want || found != c.found {
+			t.Errorf("%q (fold %v): want %d %v, got %d %v", c.piece, c.foldCase, c.want, c.found, got, found)
+		}
+	}
+
+	tk.FoldCase = true
+	want := map[int][]int{0: {4}, 1: {2}, 2: {3}, 3: {4}}
+	assertDeepEqual(t, want, tk.buildDag([]rune("at&t")))
+	tk.AddWord("iPhone", 10)
+	got, _ := tk.lookup("IPHONE")
+	assertEqual(t, 10, got)
+}
+
+func TestEdgeScorer(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天 50 n",
+		"天氣 30 n",
+		"氣 5 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "今天天氣很好"
+	want := []string{"今天", "天氣", "很", "好"}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+	// The default scorer matches a nil EdgeScorer.
+	tk.EdgeScorer = func(word string, freq int, size int64) float64 {
+		return math.Log(math.Max(float64(freq), 1)) - math.Log(float64(size))
+	}
+	assertDeepEqual(t, want, tk.Cut(text, false))
+
+	// Every word scores the same, so the path with the most
+	// words wins.
+	tk.EdgeScorer = func(word string, freq int, size int64) float64 {
+		return 1
+	}
+	assertDeepEqual(t, []string{"今", "天", "天", "氣", "很", "好"}, tk.Cut(text, false))
+}
+
+func TestLengthBonus(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"中華 100 nz",
+		"民國 200 nz",
+		"中華民國 1 ns",
+		"的 10000 uj",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "中華民國的"
+	assertDeepEqual(t, []string{"中華", "民國", "的"}, tk.Cut(text, false))
+	WithLengthBonus(0)(&tk)
+	assertDeepEqual(t, []string{"中華", "民國", "的"}, tk.Cut(text, false))
+	WithLengthBonus(1)(&tk)
+	assertDeepEqual(t, []string{"中華民國", "的"}, tk.Cut(text, false))
+}
+
+func TestMaxWordLen(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"中華 10 n",
+		"中華民國 20 ns",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := []rune("中華民國")
+	assertDeepEqual(t, map[int][]int{0: {2, 4}, 1: {2}, 2: {3}, 3: {4}}, tk.buildDag(text))
+	tk.MaxWordLen = 3
+	assertDeepEqual(t, map[int][]int{0: {2}, 1: {2}, 2: {3}, 3: {4}}, tk.buildDag(text))
+}
+
+func TestBestDagPath(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今 10 t",
+		"今天 100 t",
+		"天 50 n",
+		"天天 20 d",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+		"天天氣好 1 x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := []string{"今天天氣很好", "天天天天氣好今天", "好", ""}
+	for _, maxLen := range []int{0, 1, 2} {
+		tk.MaxWordLen = maxLen
+		for _, text := range texts {
+			runes := []rune(text)
+			want := findDagPath(runes, tk.calcDagProba(runes, tk.buildDag(runes)))
+			assertDeepEqual(t, want, tk.bestDagPath(runes))
+		}
 	}
 }
 
-func TestBuildDAG(t *testing.T) {
-	pd := newJiebaPrefixDictionary()
-	cases := []struct {
-		text string
-		want map[int][]int
-	}{
-		{"今天天氣很好", map[int][]int{
-			0: {1, 2}, // text[0:1], text[0:2] == 今, 今天
-			1: {2, 3}, // text[1:2], text[1:3] == 天, 天天
-			2: {3},    // text[2:3] == 天
-			3: {4},    // text[3:4] == 氣
-			4: {5},    // text[4:5] == 很
-			5: {6},    // text[5:6] == 好
-		}},
-		{"我昨天去上海交通大學與老師討論量子力學", map[int][]int{
-			0:  {1},
-			1:  {2, 3}, // 昨 昨天
-			2:  {3},
-			3:  {4},
-			4:  {5, 6}, // 上 上海
-			5:  {6},
-			6:  {7, 8}, // 交 交通
-			7:  {8},
-			8:  {9},
-			9:  {10},
-			10: {11},
-			11: {12},
-			12: {13},
-			13: {14},
-			14: {15},
-			15: {16, 17}, // 量 量子
-			16: {17, 18}, // 子 子力
-			17: {18},
-			18: {19},
-		}},
-		{"这一刹那的撙近", map[int][]int{
-			0: {1},
-			1: {2, 3, 4}, // 一 一刹 一刹那
-			2: {3, 4},    // 刹 刹那
-			3: {4},
-			4: {5},
-			5: {6},
-			6: {7},
-		}},
-		{"撙", map[int][]int{0: {1}}}, // "撙" is in prefix dict; has count == 0.
+// Cuts in parallel each take their own DAG workspace.
+func TestBestDagPathConcurrent(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 100 t",
+		"天 50 n",
+		"天天 20 d",
+		"天氣 30 n",
+		"很 80 d",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	for _, c := range cases {
-		t.Run(c.text, func(t *testing.T) {
-			got := pd.buildDag(c.text)
-			assertDeepEqual(t, c.want, got)
-		})
+	texts := []string{"今天天氣很好", strings.Repeat("天天氣", 50), "好", "很好今天"}
+	wants := [][][2]int{}
+	for _, text := range texts {
+		wants = append(wants, tk.bestDagPath([]rune(text)))
 	}
+	wg := sync.WaitGroup{}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				k := (w + n) % len(texts)
+				got := tk.bestDagPath([]rune(texts[k]))
+				if !reflect.DeepEqual(wants[k], got) {
+					t.Errorf("%q: want %v, got %v", texts[k], wants[k], got)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
 }
 
 func TestMaxIndexProba(t *testing.T) {
@@ -165,6 +1567,18 @@ func TestMaxIndexProba(t *testing.T) {
 			4,
 			-3.14e100,
 		},
+		// The best item is compared with the best so far, not
+		// with the item before it.
+		{
+			[]tailProba{
+				{1, -5.0},
+				{2, -9.0},
+				{3, -7.0},
+			},
+			1,
+			-5.0,
+		},
+		{nil, -1, -3.14e100},
 	}
 	for i, c := range cases {
 		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
@@ -175,6 +1589,62 @@ func TestMaxIndexProba(t *testing.T) {
 	}
 }
 
+// The DAG probabilities and path are the same on every run. Go
+// randomizes map iteration, so a dependence on it would show up
+// over many runs.
+func TestFindDagPathDeterministic(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"我 50 r",
+		"昨天 40 t",
+		"去 30 v",
+		"上 20 f",
+		"上海 20 ns",
+		"海 20 n",
+		"交通 20 n",
+		"大學 20 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runes := []rune("我昨天去上海交通大學")
+	wantProba := tk.calcDagProba(runes, tk.buildDag(runes))
+	wantPath := findDagPath(runes, wantProba)
+	// 上海 beats 上 and 海.
+	assertDeepEqual(t, [2]int{4, 6}, wantPath[3])
+	for i := 0; i < 200; i++ {
+		dagProba := tk.calcDagProba(runes, tk.buildDag(runes))
+		assertDeepEqual(t, wantProba, dagProba)
+		assertDeepEqual(t, wantPath, findDagPath(runes, dagProba))
+		assertDeepEqual(t, wantPath, tk.bestDagPath(runes))
+	}
+}
+
+// A DAG that lacks an edge doesn't cut the path short.
+func TestFindDagPathMissingEdge(t *testing.T) {
+	textRunes := []rune("今天天氣很好")
+	dagProba := map[int][]tailProba{
+		5: {{6, 1.1}},           // 好
+		3: {{4, 1.1}},           // 氣
+		2: {{2, 1.1}},           // An edge that doesn't advance.
+		1: {{2, 1.1}, {3, 2.2}}, // 天, 天天
+		0: {{2, 2.2}},           // 今天
+		// No edges from 4 (很).
+	}
+	want := [][2]int{{0, 2}, {2, 3}, {3, 4}, {4, 5}, {5, 6}}
+	assertDeepEqual(t, want, findDagPath(textRunes, dagProba))
+	assertDeepEqual(t, [][2]int{{0, 1}, {1, 2}}, findDagPath([]rune("今天"), nil))
+
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"今天 100 t", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.bigrams = &bigramTable{counts: map[[2]string]int{}, totals: map[string]int{}}
+	dag := map[int][]int{0: {2}, 3: {4}}
+	assertDeepEqual(t, [][2]int{{0, 2}, {2, 3}, {3, 4}}, tk.bigramPath([]rune("今天很好"), dag))
+}
+
 func TestFindDagPath(t *testing.T) {
 	cases := []struct {
 		text     string
@@ -263,25 +1733,51 @@ func TestFindDagPath(t *testing.T) {
 	}
 	for _, c := range cases {
 		t.Run(c.text, func(t *testing.T) {
-			got := findDagPath(c.text, c.dagProba)
+			got := findDagPath([]rune(c.text), c.dagProba)
 			assertDeepEqual(t, c.want, got)
 		})
 	}
 }
 
+func TestCutSingleRune(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"撙節 3 v", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HMM isn't needed, so it isn't used.
+	tk.hmmErr = errors.New("no HMM")
+	for _, text := range []string{"撙", "好", "國"} {
+		words, _ := tk.cutZh([]rune(text), true)
+		assertDeepEqual(t, []string{text}, words)
+	}
+	assertDeepEqual(t, []string{"撙", "好"}, tk.Cut("撙，好", true))
+}
+
+func TestCutDagInvalidRune(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"今天 100 t", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A surrogate half is encoded as U+FFFD, which is longer.
+	want := []string{"今天", "\uFFFD", "好"}
+	assertDeepEqual(t, want, tk.cutDAG([]rune{'今', '天', 0xD800, '好'}))
+}
+
 func TestCutDag(t *testing.T) {
 	tk := NewJiebaTokenizer()
 	t.Run("cut dag 1", func(t *testing.T) {
 		text := "今天天氣很好"
 		want := []string{"今天", "天", "氣", "很", "好"}
-		got := tk.cutDAG(text)
+		got := tk.cutDAG([]rune(text))
 		assertDeepEqual(t, want, got)
 	})
 
 	t.Run("cut dag 2", func(t *testing.T) {
 		text := "我昨天去上海交通大學與老師討論量子力學"
 		want := []string{"我", "昨天", "去", "上海", "交通", "大", "學", "與", "老", "師", "討", "論", "量子", "力", "學"}
-		got := tk.cutDAG(text)
+		got := tk.cutDAG([]rune(text))
 		assertDeepEqual(t, want, got)
 	})
 }
@@ -344,6 +1840,55 @@ func TestStateTransitionRoute(t *testing.T) {
 	}
 }
 
+func TestStateTransitionRouteBelowMinFloat(t *testing.T) {
+	hmm := newJiebaHMM()
+	// Runes without emission probabilities push every route
+	// below minFloat. A route must still be chosen.
+	hsProb := map[int]map[string]float64{
+		0: {"B": 2 * minFloat, "M": 3 * minFloat, "E": 3 * minFloat, "S": 2 * minFloat},
+	}
+	for _, state := range []string{"B", "M", "E", "S"} {
+		route := hmm.stateTransitionRoute(1, state, hsProb)
+		if route.from == "" {
+			t.Errorf("want a route to %s, got none", state)
+		}
+	}
+}
+
+func TestLoadEmitProba(t *testing.T) {
+	want, err := loadEmitProba("prob_emit.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gobFile := writeEmitGob(t, want)
+	got, err := loadEmitProba(gobFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, want, got)
+
+	_, err = loadEmitProba(filepath.Join(t.TempDir(), "missing.gob"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("want fs.ErrNotExist, got %v", err)
+	}
+}
+
+// Write emission probabilities to a gob file in a temporary
+// directory, and return the file's name.
+func writeEmitGob(tb testing.TB, emitP map[string]map[string]float64) string {
+	tb.Helper()
+	name := filepath.Join(tb.TempDir(), "prob_emit.gob")
+	f, err := os.Create(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	if err := gob.NewEncoder(f).Encode(emitP); err != nil {
+		tb.Fatal(err)
+	}
+	return name
+}
+
 func TestCutHMM(t *testing.T) {
 	tk := NewJiebaTokenizer()
 	t.Run("cut hmm 1", func(t *testing.T) {
@@ -362,6 +1907,44 @@ func TestCutHMM(t *testing.T) {
 		got := tk.cutHMM(text, vPath)
 		assertDeepEqual(t, want, got)
 	})
+
+	t.Run("cut hmm 3", func(t *testing.T) {
+		// Runes after the last E or S are kept.
+		text := "天氣很好"
+		vPath := []string{"B", "E", "S", "B"}
+		want := []string{"天氣", "很", "好"}
+		got := tk.cutHMM(text, vPath)
+		assertDeepEqual(t, want, got)
+	})
+}
+
+// Runs of single runes must reach HMM whether they come before,
+// after or around a dictionary word.
+func TestCutZhSingletonRuns(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"今天 100 t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No emission probabilities, so HMM gains nothing from the
+	// runes and every route ties.
+	tk.hmm = newTestHMM(map[string]map[string]float64{})
+	cases := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"single, single, long", "甲乙今天", []string{"甲", "乙", "今天"}},
+		{"long, single, single", "今天甲乙", []string{"今天", "甲", "乙"}},
+		{"single, long, single", "甲今天乙", []string{"甲", "今天", "乙"}},
+		{"single, long, run", "甲今天乙丙丁", []string{"甲", "今天", "乙", "丙", "丁"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			words, _ := tk.cutZh([]rune(c.text), true)
+			assertDeepEqual(t, c.want, words)
+		})
+	}
 }
 
 func TestCutNonZh(t *testing.T) {
@@ -383,6 +1966,107 @@ func TestCutNonZh(t *testing.T) {
 	}
 }
 
+// Non-Han blocks without letters or digits are dropped unless
+// KeepPunctuation is set, while the "," of "ok, 好" is kept.
+func TestKeepPunctuation(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"今天 100 t", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []string{}, tk.cutNonZh("，"))
+	assertDeepEqual(t, []string{"今天", "好"}, tk.Cut("今天，好。", false))
+	assertDeepEqual(t, []string{"ok", ",", "好"}, tk.Cut("ok, 好。", false))
+
+	tk.KeepPunctuation = true
+	assertDeepEqual(t, []string{"，"}, tk.cutNonZh("，"))
+	assertDeepEqual(t, []string{"！", "?"}, tk.cutNonZh("！ ?"))
+	assertDeepEqual(t, []string{"今天", "，", "好", "。"}, tk.Cut("今天，好。", false))
+	assertDeepEqual(t, []string{"ok", ",", "好", "。"}, tk.Cut("ok, 好。", false))
+}
+
+func TestKeepNumbers(t *testing.T) {
+	tk := Tokenizer{KeepNumbers: true}
+	err := tk.buildPrefixDictionary([]string{"好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"1,000", []string{"1,000"}},
+		{"1,234,567.89", []string{"1,234,567.89"}},
+		{"1.5", []string{"1.5"}},
+		{"好,好", []string{"好", "好"}},
+		{"1,2", []string{"1", ",", "2"}},
+		{"1234,567", []string{"1234", ",", "567"}},
+		{"3.5.", []string{"3.5", "."}},
+		{"end. 2,000好", []string{"end", ".", "2,000", "好"}},
+	}
+	for _, c := range cases {
+		got := tk.Cut(c.text, false)
+		if !reflect.DeepEqual(c.want, got) {
+			t.Errorf("case %q: want %v, got %v", c.text, c.want, got)
+		}
+	}
+
+	tk.KeepNumbers = false
+	assertDeepEqual(t, []string{"1", ",", "000"}, tk.Cut("1,000", false))
+}
+
+func TestCollapseRepeats(t *testing.T) {
+	tk := Tokenizer{CollapseRepeats: true}
+	err := tk.buildPrefixDictionary([]string{
+		"哈 10 e",
+		"好 90 a",
+		"好好 30 z",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"哈哈哈哈", []string{"哈哈哈哈"}},
+		{"哈哈好好哈", []string{"哈哈", "好好", "哈"}},
+		{"a a a", []string{"a", "a", "a"}},
+		{"好!!! ok??", []string{"好", "!!!", "ok", "??"}},
+		{"哈a!!哈", []string{"哈", "a", "!!", "哈"}},
+	}
+	for _, c := range cases {
+		got := tk.Cut(c.text, false)
+		if !reflect.DeepEqual(c.want, got) {
+			t.Errorf("case %q: want %v, got %v", c.text, c.want, got)
+		}
+	}
+
+	tk.CollapseRepeats = false
+	assertDeepEqual(t, []string{"哈", "哈", "哈", "哈"}, tk.Cut("哈哈哈哈", false))
+}
+
+func TestCutNonZhKeepURLs(t *testing.T) {
+	cases := []struct {
+		text     string
+		keepURLs bool
+		want     []string
+	}{
+		{"https://example.com/path?q=1", true, []string{"https://example.com/path?q=1"}},
+		{"visit https://example.com/path?q=1 or mail a@b.com", true, []string{"visit", "https://example.com/path?q=1", "or", "mail", "a@b.com"}},
+		{"mail first.last@mail.example.org", true, []string{"mail", "first.last@mail.example.org"}},
+		{"a@b.com", false, []string{"a", "@", "b", ".", "com"}},
+		{"http://a.b?q=1", false, []string{"http", ":", "/", "/", "a", ".", "b", "?", "q", "=", "1"}},
+	}
+	for _, c := range cases {
+		tk := Tokenizer{KeepURLs: c.keepURLs}
+		got := tk.cutNonZh(c.text)
+		if !reflect.DeepEqual(c.want, got) {
+			t.Errorf("case %q: want %v, got %v", c.text, c.want, got)
+		}
+	}
+}
+
 // func TestInitialize(t *testing.T) {
 // 	t.Run("with custom dictionary", func(t *testing.T) {
 // 		f, _ := os.CreateTemp("", "aaa.txt")
@@ -428,40 +2112,243 @@ func TestCutNonZh(t *testing.T) {
 // 	})
 // }
 
-func TestBuildPrefixDict(t *testing.T) {
+func TestBuildPrefixDict(t *testing.T) {
+	tk := Tokenizer{}
+	input := []string{
+		"AT&T 3 nz",
+		"B超 3 n",
+		"c# 3 nz",
+		"C# 3",
+		"江南style 3 n",
+		"江南 4986 ns",
+	}
+	want := map[string]int{
+		"A":       0,
+		"AT":      0,
+		"AT&":     0,
+		"AT&T":    3,
+		"B":       0,
+		"B超":      3,
+		"c":       0,
+		"c#":      3,
+		"C#":      3,
+		"C":       0,
+		"江":       0,
+		"江南":      4986,
+		"江南s":     0,
+		"江南st":    0,
+		"江南sty":   0,
+		"江南styl":  0,
+		"江南style": 3,
+	}
+	err := tk.buildPrefixDictionary(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, want, tk.pd.termFreq)
+}
+
+func TestBuildPrefixDictDuplicates(t *testing.T) {
+	lines := []string{
+		"今天 10 t",
+		"天氣 3 n",
+		"今天 20 t",
+		"今 5 t",
+	}
+	f, err := os.CreateTemp("", "dict*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	f.Close()
+
+	pd, err := loadPrefixDictionaryFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := Tokenizer{}
+	if err := tk.buildPrefixDictionary(lines); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(18), pd.size)
+	assertEqual(t, pd.size, tk.pd.size)
+	assertDeepEqual(t, tk.pd.termFreq, pd.termFreq)
+	assertEqual(t, 10, tk.pd.termFreq["今天"])
+}
+
+func TestMinFreq(t *testing.T) {
+	f, err := os.CreateTemp("", "dict*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("今天 100 t\n天氣 30 n\n氣很 2 x\n很 80 d\n"))
+	f.Close()
+
+	text := "氣很"
+	tk := NewTokenizer(f.Name())
+	assertDeepEqual(t, []string{"氣很"}, tk.Cut(text, false))
+	assertEqual(t, int64(212), tk.pd.size)
+
+	tk = NewTokenizer(f.Name(), WithMinFreq(3))
+	assertDeepEqual(t, []string{"氣", "很"}, tk.Cut(text, false))
+	assertEqual(t, int64(210), tk.pd.size)
+	assertEqual(t, 0, tk.pd.termFreq["氣很"])
+	if err := tk.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(210), tk.pd.size)
+}
+
+func TestLoadPrefixDictionaryFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    map[string]int
+		wantErr bool
+	}{
+		{
+			"pieces",
+			"AT&T 3 nz\n江南style 3\n江南 4986 ns\n\n",
+			map[string]int{
+				"A": 0, "AT": 0, "AT&": 0, "AT&T": 3,
+				"江": 0, "江南": 4986, "江南s": 0, "江南st": 0,
+				"江南sty": 0, "江南styl": 0, "江南style": 3,
+			},
+			false,
+		},
+		{"no frequency", "今天\n", nil, true},
+		{"bad frequency", "今天 1O t\n", nil, true},
+		{"empty frequency", "今天  t\n", nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := os.CreateTemp("", "dict*.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			f.Write([]byte(c.content))
+			f.Close()
+
+			pd, err := loadPrefixDictionaryFile(f.Name())
+			if c.wantErr {
+				if err == nil {
+					t.Fatal("want error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertDeepEqual(t, c.want, pd.termFreq)
+			assertEqual(t, int64(4992), pd.size)
+		})
+	}
+}
+
+func TestFreqOverflow(t *testing.T) {
+	content := "好 1\n好的 99999999999999999999 u\n"
+	filename := filepath.Join(t.TempDir(), "dict.txt")
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pd, err := loadPrefixDictionaryFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, math.MaxInt, pd.termFreq["好的"])
+	if !errors.Is(pd.overflow, strconv.ErrRange) {
+		t.Errorf("want strconv.ErrRange, got %v", pd.overflow)
+	}
+
+	// Out of range frequencies are an error by default.
+	_, err = NewTokenizerWith(strings.NewReader(content), nil)
+	if !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("want strconv.ErrRange, got %v", err)
+	}
+	_, err = LoadDictionary(filename)
+	if !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("want strconv.ErrRange, got %v", err)
+	}
 	tk := Tokenizer{}
-	input := []string{
-		"AT&T 3 nz",
-		"B超 3 n",
-		"c# 3 nz",
-		"C# 3",
-		"江南style 3 n",
-		"江南 4986 ns",
+	if err := tk.buildPrefixDictionary([]string{"很 90 d"}); err != nil {
+		t.Fatal(err)
 	}
-	want := map[string]int{
-		"A":       0,
-		"AT":      0,
-		"AT&":     0,
-		"AT&T":    3,
-		"B":       0,
-		"B超":      3,
-		"c":       0,
-		"c#":      3,
-		"C#":      3,
-		"C":       0,
-		"江":       0,
-		"江南":      4986,
-		"江南s":     0,
-		"江南st":    0,
-		"江南sty":   0,
-		"江南styl":  0,
-		"江南style": 3,
+	err = tk.LoadDictionaries([]string{filename}, MergeReplace)
+	if !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("want strconv.ErrRange, got %v", err)
 	}
-	err := tk.buildPrefixDictionary(input)
+	_, found := tk.pd.termFreq["好的"]
+	assertEqual(t, false, found)
+
+	// Or clamped.
+	WithFreqOverflow(FreqOverflowClamp)(&tk)
+	if err := tk.LoadDictionaries([]string{filename}, MergeReplace); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, math.MaxInt, tk.pd.termFreq["好的"])
+	clamped, err := NewTokenizerWith(strings.NewReader(content), nil, WithFreqOverflow(FreqOverflowClamp))
 	if err != nil {
 		t.Fatal(err)
 	}
-	assertDeepEqual(t, want, tk.pd.termFreq)
+	assertEqual(t, math.MaxInt, clamped.pd.termFreq["好的"])
+	assertEqual(t, addSize(1, math.MaxInt), clamped.pd.size)
+	assertDeepEqual(t, []string{"好的"}, clamped.Cut("好的", false))
+
+	fromFile := NewTokenizer(filename, WithFreqOverflow(FreqOverflowClamp))
+	if err := fromFile.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	fromFile.freqOverflow = FreqOverflowError
+	if err := fromFile.Reset(); !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("want strconv.ErrRange, got %v", err)
+	}
+}
+
+func TestEmptyDictionary(t *testing.T) {
+	for _, content := range []string{"", "\n\n", "今天 0\n"} {
+		filename := filepath.Join(t.TempDir(), "dict.txt")
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadBaseDictionaryFile(filename)
+		if !errors.Is(err, ErrEmptyDictionary) {
+			t.Errorf("%q: want ErrEmptyDictionary, got %v", content, err)
+		}
+		_, err = NewTokenizerWith(strings.NewReader(content), nil)
+		if !errors.Is(err, ErrEmptyDictionary) {
+			t.Errorf("%q: want ErrEmptyDictionary, got %v", content, err)
+		}
+		// Supplementary dictionaries may be empty.
+		if _, err := LoadDictionary(filename); err != nil {
+			t.Errorf("%q: %v", content, err)
+		}
+		tk := Tokenizer{}
+		if err := tk.buildPrefixDictionary([]string{"好 90 a"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tk.LoadDictionaries([]string{filename}, MergeReplace); err != nil {
+			t.Errorf("%q: %v", content, err)
+		}
+		assertDeepEqual(t, []string{"好"}, tk.Cut("好", false))
+	}
+
+	// A dictionary that loses its words later counts as size 1,
+	// so scores stay finite.
+	tk := Tokenizer{}
+	if err := tk.buildPrefixDictionary([]string{"今天 10 t", "好 5 a"}); err != nil {
+		t.Fatal(err)
+	}
+	tk.TuneFreq("今天", -10)
+	tk.TuneFreq("好", -5)
+	assertEqual(t, int64(0), tk.pd.size)
+	assertDeepEqual(t, []string{"今", "天", "好"}, tk.Cut("今天好", false))
+	score := tk.ScoreSegmentation([]string{"今", "天"})
+	if math.IsInf(score, 0) || math.IsNaN(score) {
+		t.Errorf("want a finite score, got %v", score)
+	}
 }
 
 func TestBuildPrefixDictFromScratch(t *testing.T) {
@@ -496,6 +2383,301 @@ func TestAddWord(t *testing.T) {
 	}
 }
 
+// AddWord used to take the write lock and then call addTerm,
+// which takes it again, so it hung on any tokenizer.
+func TestAddWordLiveTokenizer(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"今天 10 t", "天氣 3 n", "好 90 a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "今天天氣好左和右"
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wg := sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					tk.Cut(text, false)
+				}
+			}()
+		}
+		tk.AddWord("左和右", 20)
+		tk.AddWord("天氣", 30)
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("AddWord deadlocked")
+	}
+	assertEqual(t, 20, tk.pd.termFreq["左和右"])
+	assertEqual(t, 30, tk.pd.termFreq["天氣"])
+	assertDeepEqual(t, []string{"今天", "天氣", "好"}, tk.Cut("今天天氣好", false))
+}
+
+func TestAddWordSuggestFreq(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"联合国 500 nt",
+		"教科文 20 j",
+		"组织 800 n",
+		"联合国教科文组织 1 nt",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "联合国教科文组织好"
+	assertDeepEqual(t, []string{"联合国", "教科文", "组织", "好"}, tk.Cut(text, false))
+
+	tk.AddWord("联合国教科文组织", 0)
+	assertDeepEqual(t, []string{"联合国教科文组织", "好"}, tk.Cut(text, false))
+
+	// 500 * 20 * 800 / size^2 is about 4, below the floor.
+	assertEqual(t, minSuggestedFreq, tk.pd.termFreq["联合国教科文组织"])
+}
+
+func TestAddWordPOS(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"创新 50 v",
+		"创 10 v",
+		"办 20 v",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.AddWordPOS("创新办", 3000, "i")
+	tk.RebuildPrefixes()
+	want := []Token{
+		{Word: "创新办", Start: 0, End: 3, POS: "i"},
+		{Word: "好", Start: 3, End: 4},
+	}
+	assertDeepEqual(t, want, tk.Tokenize("创新办好", false))
+
+	tk.DefaultPOS = "x"
+	want[1].POS = "x"
+	assertDeepEqual(t, want, tk.Tokenize("创新办好", false))
+
+	// A new POS replaces the old one.
+	tk.AddWordPOS("创新办", 3000, "nt")
+	assertEqual(t, "nt", tk.Tokenize("创新办", false)[0].POS)
+}
+
+func TestRebuildPrefixes(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"量子 50 n",
+		"力学 40 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.AddWord("量子力学", 100)
+	tk.AddWord("左和右", 20)
+	text := "量子力学左和右好"
+	// The pieces 量子力, 左 and 左和 are missing.
+	assertDeepEqual(t, []string{"量子", "力学", "左", "和", "右", "好"}, tk.Cut(text, false))
+
+	tk.RebuildPrefixes()
+	assertDeepEqual(t, []string{"量子力学", "左和右", "好"}, tk.Cut(text, false))
+	assertEqual(t, 0, tk.pd.termFreq["量子力"])
+	assertEqual(t, 50, tk.pd.termFreq["量子"])
+	assertEqual(t, int64(300), tk.pd.size)
+}
+
+func TestAddWords(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"量子 50 n",
+		"力学 40 n",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.AddWords(map[string]int{
+		"量子力学": 100,
+		"左和右":  20,
+		"好":    10,
+	})
+	// The pieces 量子力, 左 and 左和 are added with the words.
+	text := "量子力学左和右好"
+	assertDeepEqual(t, []string{"量子力学", "左和右", "好"}, tk.Cut(text, false))
+	assertEqual(t, 0, tk.pd.termFreq["量子力"])
+	assertEqual(t, 50, tk.pd.termFreq["量子"])
+	// 好 is replaced, so its old frequency is no longer counted.
+	assertEqual(t, int64(50+40+100+20+10), tk.pd.size)
+}
+
+func TestSizeBeyondInt32(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{"好 2000000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk.AddWords(map[string]int{
+		"上海": 2_000_000_000,
+		"交通": 2_000_000_000,
+		"大学": 2_000_000_000,
+	})
+	assertEqual(t, int64(8_000_000_000), tk.pd.size)
+	assertEqual(t, true, tk.TuneFreq("大学", -1_000_000_000))
+	assertEqual(t, int64(7_000_000_000), tk.pd.size)
+	assertDeepEqual(t, []string{"上海", "交通", "大学"}, tk.Cut("上海交通大学", false))
+
+	// Past the int64 range, the size stays at the limit.
+	assertEqual(t, int64(math.MaxInt64), addSize(math.MaxInt64-1, 10))
+	assertEqual(t, int64(math.MinInt64), addSize(math.MinInt64+1, -10))
+}
+
+func TestStats(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"上海 5 ns",
+		"上海交通 20 ns",
+		"大學 30 n",
+		"大 10 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fragments are 上 and 上海交. 大, the prefix of 大學,
+	// is a word.
+	want := DictStats{Words: 4, Fragments: 2, Size: 65}
+	assertDeepEqual(t, want, tk.Stats())
+}
+
+func TestTuneFreq(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"上 50 f",
+		"海 40 n",
+		"上海 5 ns",
+		"交 30 v",
+		"上海交通 20 ns",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []string{"上", "海", "交"}, tk.Cut("上海交", false))
+
+	// Nudge 上海 up until it stays merged.
+	size := tk.pd.size
+	for tk.Cut("上海交", false)[0] != "上海" {
+		if !tk.TuneFreq("上海", 5) {
+			t.Fatal("want 上海 to be tunable")
+		}
+	}
+	assertDeepEqual(t, []string{"上海", "交"}, tk.Cut("上海交", false))
+	tuned := tk.pd.termFreq["上海"]
+	assertEqual(t, size+int64(tuned-5), tk.pd.size)
+
+	size = tk.pd.size
+	assertEqual(t, true, tk.TuneFreq("上海", -100))
+	assertEqual(t, 0, tk.pd.termFreq["上海"])
+	assertEqual(t, size-int64(tuned), tk.pd.size)
+	assertDeepEqual(t, []string{"上", "海", "交"}, tk.Cut("上海交", false))
+
+	size = tk.pd.size
+	assertEqual(t, false, tk.TuneFreq("下海", 10))
+	_, found := tk.pd.termFreq["下海"]
+	assertEqual(t, false, found)
+	assertEqual(t, size, tk.pd.size)
+}
+
+func TestReset(t *testing.T) {
+	f, err := os.CreateTemp("", "dict*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("今天 10 t\n天氣 3\n"))
+	f.Close()
+
+	tk := NewTokenizer(f.Name())
+	assertEqual(t, true, tk.Ready())
+	assertEqual(t, f.Name(), tk.Source())
+	tk.AddWord("左和右", 20)
+	tk.AddWord("天氣", 30)
+	if err := tk.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{
+		"今":  0,
+		"今天": 10,
+		"天":  0,
+		"天氣": 3,
+	}
+	assertDeepEqual(t, want, tk.pd.termFreq)
+	assertEqual(t, int64(13), tk.pd.size)
+}
+
+func TestSharedDictionary(t *testing.T) {
+	f, err := os.CreateTemp("", "dict*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("今天 10 t\n天氣 3\n"))
+	f.Close()
+
+	storage := func(tk *Tokenizer) uintptr {
+		return reflect.ValueOf(tk.pd.termFreq).Pointer()
+	}
+	tk1 := NewTokenizer(f.Name())
+	tk2 := NewTokenizer(f.Name())
+	assertEqual(t, storage(tk1), storage(tk2))
+
+	// Changing one tokenizer copies its dictionary first.
+	tk1.AddWord("天氣", 30)
+	if storage(tk1) == storage(tk2) {
+		t.Error("want separate dictionaries after AddWord")
+	}
+	assertEqual(t, 30, tk1.pd.termFreq["天氣"])
+	assertEqual(t, 3, tk2.pd.termFreq["天氣"])
+	assertEqual(t, int64(13), tk2.pd.size)
+	tk2.TuneFreq("今天", 5)
+	assertEqual(t, 15, tk2.pd.termFreq["今天"])
+	assertEqual(t, 10, NewTokenizer(f.Name()).pd.termFreq["今天"])
+
+	// Pruning doesn't affect the shared copy either.
+	tk3 := NewTokenizer(f.Name(), WithMinFreq(5))
+	assertEqual(t, 0, tk3.pd.termFreq["天氣"])
+	assertEqual(t, 3, NewTokenizer(f.Name()).pd.termFreq["天氣"])
+
+	// Reset shares again.
+	if err := tk1.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, storage(NewTokenizer(f.Name())), storage(tk1))
+
+	// A changed file is loaded anew.
+	if err := os.WriteFile(f.Name(), []byte("今天 10 t\n天氣 3\n很 8 d\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tk4 := NewTokenizer(f.Name())
+	assertEqual(t, 8, tk4.pd.termFreq["很"])
+	// It replaces the entry of the old version.
+	abs, err := filepath.Abs(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedDictionaries.Lock()
+	assertEqual(t, storage(tk4), reflect.ValueOf(loadedDictionaries.entries[abs].pd.termFreq).Pointer())
+	loadedDictionaries.Unlock()
+	if err := tk1.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, storage(tk4), storage(tk1))
+}
+
 //
 // Benchmarks.
 //
@@ -511,6 +2693,20 @@ func BenchmarkCutBigTextParallel(b *testing.B) {
 	}
 }
 
+// 702,416,401 ns/op, 496,436,476 B/op; 498,959,368 B/op when the
+// text was copied to a []byte to find Han blocks.
+func BenchmarkCutParallelLongText(b *testing.B) {
+	tk := NewJiebaTokenizer()
+	// About 2MB.
+	text := strings.Repeat("我昨天去上海交通大學與老師討論量子力學, ok. ", 40_000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.CutParallel(text, true, 6, false)
+	}
+}
+
 // 318,559,415 ns/op
 func BenchmarkCutBigText(b *testing.B) {
 	tk := NewJiebaTokenizer()
@@ -532,13 +2728,76 @@ func BenchmarkCut(b *testing.B) {
 	}
 }
 
+// Cut: 716,073 B/op, 9,019 allocs/op; CutRanges: 781,616 B/op,
+// 9,021 allocs/op; 871,736 B/op and 17% slower than Cut when the
+// ranges were found by locating each token.
+func BenchmarkCutRanges(b *testing.B) {
+	tk := NewJiebaTokenizer()
+	text := strings.Repeat("我昨天去上海交通大學與老師討論量子力學, hello  world! ", 100)
+
+	b.Run("Cut", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tk.Cut(text, true)
+		}
+	})
+	b.Run("CutRanges", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tk.CutRanges(text, true)
+		}
+	})
+}
+
+// 16,301,702 ns/op; 19,336,735 ns/op without the fast path for
+// text without Han characters.
+func BenchmarkCutEnglish(b *testing.B) {
+	tk := Tokenizer{}
+	tk.buildPrefixDictionary([]string{"好 90 a"})
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog, 42 times! ", 2000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.Cut(text, true)
+	}
+}
+
+// A 5MB run of alternating letters and symbols, such as
+// mangled base64, is 5 million tokens.
+// 1,288,107,240 ns/op, 462,910,584 B/op, 2,500,095 allocs/op;
+// 2,476,281,050 ns/op, 1,733,460,296 B/op, 10,000,174 allocs/op
+// before cutPatterns found matches one at a time.
+func BenchmarkCutLongNonHan(b *testing.B) {
+	tk := Tokenizer{}
+	tk.buildPrefixDictionary([]string{"好 90 a"})
+	text := strings.Repeat("a+", 2_500_000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.Cut(text, false)
+	}
+}
+
 // 4,4289 ns/op
 func BenchmarkBuildDag(b *testing.B) {
-	pd := newJiebaPrefixDictionary()
+	tk := NewJiebaTokenizer()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.buildDag([]rune("我昨天去上海交通大學與老師討論量子力學"))
+	}
+}
+
+// 2,301,137 ns/op; 1,425,746,175 ns/op without MaxWordLen.
+func BenchmarkBuildDagRepeatedPrefix(b *testing.B) {
+	tk := Tokenizer{}
+	tk.buildPrefixDictionary([]string{strings.Repeat("啊", 1000) + " 1"})
+	text := []rune(strings.Repeat("啊", 1000))
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		pd.buildDag("我昨天去上海交通大學與老師討論量子力學")
+		tk.buildDag(text)
 	}
 }
 
@@ -566,19 +2825,93 @@ func BenchmarkFindDagPath(b *testing.B) {
 		0:  {{1, 1.1}},
 	}
 
+	textRunes := []rune("我昨天去上海交通大學與老師討論量子力學")
+
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		findDagPath("我昨天去上海交通大學與老師討論量子力學", dagProba)
+		findDagPath(textRunes, dagProba)
 	}
 }
 
-// 1,039 ns/op
+// 1,965 ns/op, 720 B/op, 5 allocs/op; 2,184 ns/op, 1,008 B/op,
+// 19 allocs/op before slicing pieces out of one string;
+// 5,717 ns/op, 44 allocs/op before reusing DAG workspaces.
 func BenchmarkCutDag(b *testing.B) {
 	tk := NewJiebaTokenizer()
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		tk.cutDAG("我昨天去上海交通大學與老師討論量子力學")
+		tk.cutDAG([]rune("我昨天去上海交通大學與老師討論量子力學"))
+	}
+}
+
+// 6,960,534 B/op, 30 allocs/op; 12,492,670 B/op, 68,482
+// allocs/op before slicing pieces out of one string (18.2ms and
+// 22.7ms on the same machine). 12,930,239 ns/op before that;
+// 39,772,632 ns/op, 13,376,326 B/op, 178,995 allocs/op before
+// reusing DAG workspaces; 61,034,113 ns/op, 34,917,631 B/op when
+// the whole DAG was kept.
+func BenchmarkCutDagLongBlock(b *testing.B) {
+	tk := NewJiebaTokenizer()
+	text := []rune(strings.Repeat("我昨天去上海交通大學與老師討論量子力學", 100_000/19))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.cutDAG(text)
+	}
+}
+
+func benchmarkWords() map[string]int {
+	words := map[string]int{}
+	for i := 0; i < 10_000; i++ {
+		words[string([]rune{0x4e00 + rune(i%500), 0x4e00 + rune(i/500), 0x9fa0})] = 5
+	}
+	return words
+}
+
+// 2,790,198 ns/op, 1,747,656 B/op, 148 allocs/op
+func BenchmarkAddWords(b *testing.B) {
+	words := benchmarkWords()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk := Tokenizer{}
+		tk.buildPrefixDictionary([]string{"好 90 a"})
+		tk.AddWords(words)
+	}
+}
+
+// 3,260,467 ns/op, 1,747,656 B/op, 148 allocs/op. AddWord
+// doesn't add prefix pieces, so RebuildPrefixes is needed to get
+// the same dictionary as AddWords.
+func BenchmarkAddWordLoop(b *testing.B) {
+	words := benchmarkWords()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk := Tokenizer{}
+		tk.buildPrefixDictionary([]string{"好 90 a"})
+		for word, freq := range words {
+			tk.AddWord(word, freq)
+		}
+		tk.RebuildPrefixes()
+	}
+}
+
+// 49 ns/op, 24 B/op, 2 allocs/op; 564 ns/op, 104 B/op, 8
+// allocs/op before skipping the DAG.
+func BenchmarkCutSingleRune(b *testing.B) {
+	tk := Tokenizer{}
+	tk.buildPrefixDictionary([]string{"撙節 3 v", "好 90 a"})
+	text := []rune("撙")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tk.cutZh(text, true)
 	}
 }
 
@@ -592,7 +2925,30 @@ func BenchmarkViterbi(b *testing.B) {
 	}
 }
 
-// 88,681,290 ns/op
+// 15,432,989 ns/op
+func BenchmarkLoadEmitJSON(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		loadEmitProba("prob_emit.json")
+	}
+}
+
+// 3,496,322 ns/op
+func BenchmarkLoadEmitGob(b *testing.B) {
+	emitP, err := loadEmitProba("prob_emit.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	gobFile := writeEmitGob(b, emitP)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		loadEmitProba(gobFile)
+	}
+}
+
+// 93,898,105 ns/op, 352,056 allocs/op. Before parsing lines by
+// hand: 88,681,290 ns/op, 701,032 allocs/op without fragments,
+// and 249,287,239 ns/op, 1,402,472 allocs/op with them.
 func BenchmarkBuildPrefDict(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		newPrefixDictionaryFromFile("dict.txt")
@@ -732,3 +3088,18 @@ func loadPrefixDictionaryFromGob() map[string]int {
 // 		}
 // 	}
 // }
+
+// Return an HMM with fixed start and transition probabilities,
+// and the given emission probabilities.
+func newTestHMM(emitProba map[string]map[string]float64) hiddenMarkovModel {
+	return newHMM(
+		map[string]float64{"B": -0.5, "E": minFloat, "M": minFloat, "S": -1.0},
+		map[string]map[string]float64{
+			"B": {"E": -0.5, "M": -1.0},
+			"E": {"B": -0.5, "S": -1.0},
+			"M": {"E": -0.5, "M": -1.0},
+			"S": {"B": -0.5, "S": -1.0},
+		},
+		emitProba,
+	)
+}
@@ -0,0 +1,134 @@
+package tokenizer
+
+import "unicode"
+
+// TokenKind is the kind of text a token holds.
+type TokenKind int
+
+const (
+	// Contains Han characters.
+	Han TokenKind = iota
+	// ASCII letters and digits, including numbers such as 3.5.
+	Alnum
+	// Punctuation only.
+	Punct
+	// Whitespace only.
+	Space
+	// Anything else, such as symbols, kana or hangul.
+	Other
+	// Han characters that aren't a dictionary word, such as a
+	// lone rare character. Only reported with
+	// Tokenizer.MarkUnknownHan; otherwise they are Han.
+	HanUnknown
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case Han:
+		return "Han"
+	case Alnum:
+		return "Alnum"
+	case Punct:
+		return "Punct"
+	case Space:
+		return "Space"
+	case HanUnknown:
+		return "HanUnknown"
+	}
+	return "Other"
+}
+
+// TypedToken is a token and its kind.
+type TypedToken struct {
+	Word string
+	Kind TokenKind
+	// See Token.FromHMM.
+	FromHMM bool
+}
+
+// Cut text and classify each token by its kind, so that tokens
+// can be routed to language-specific processing.
+func (tk *Tokenizer) CutTyped(text string, useHmm bool) []TypedToken {
+	tk.pd.lock.RLock()
+	defer tk.pd.lock.RUnlock()
+	var marks *hmmMarks
+	if useHmm {
+		marks = &hmmMarks{spans: map[[2]int]bool{}}
+	}
+	located := tk.locateTokens(text, useHmm, marks)
+	tokens := make([]TypedToken, 0, len(located))
+	for _, t := range located {
+		kind := tokenKind(t.Word)
+		if kind == Han && tk.MarkUnknownHan && !tk.isWord(t.Word) {
+			kind = HanUnknown
+		}
+		tokens = append(tokens, TypedToken{t.Word, kind, t.FromHMM})
+	}
+	return tokens
+}
+
+// Report whether word is in the dictionary with a frequency
+// above 0, as opposed to a prefix fragment or not at all.
+func (tk *Tokenizer) isWord(word string) bool {
+	freq, _ := tk.lookup(string(tk.mapRunes([]rune(word))))
+	return freq > 0
+}
+
+func tokenKind(word string) TokenKind {
+	switch {
+	case zh.MatchString(word):
+		return Han
+	case alnum.FindString(word) == word || number.MatchString(word):
+		return Alnum
+	case allRunes(word, unicode.IsPunct):
+		return Punct
+	case allRunes(word, unicode.IsSpace):
+		return Space
+	}
+	return Other
+}
+
+func allRunes(word string, f func(rune) bool) bool {
+	for _, r := range word {
+		if !f(r) {
+			return false
+		}
+	}
+	return word != ""
+}
+
+// ScriptStats holds the share of each script among the runes of a
+// text, not counting whitespace. The shares add up to 1, or are
+// all 0 for text without other runes.
+type ScriptStats struct {
+	// Han characters, as in Han tokens.
+	Han float64
+	// Latin letters, with or without diacritics, and ASCII
+	// digits, as in Alnum tokens.
+	Latin float64
+	// Everything else, such as punctuation, kana or hangul.
+	Other float64
+}
+
+// Measure the scripts text is written in, e.g. to skip Cut for
+// text that has no Han characters at all.
+func DetectScript(text string) ScriptStats {
+	han, latin, other := 0, 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			continue
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.Is(unicode.Latin, r) || r >= '0' && r <= '9':
+			latin++
+		default:
+			other++
+		}
+	}
+	total := float64(han + latin + other)
+	if total == 0 {
+		return ScriptStats{}
+	}
+	return ScriptStats{float64(han) / total, float64(latin) / total, float64(other) / total}
+}
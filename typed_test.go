@@ -0,0 +1,86 @@
+package tokenizer
+
+import "testing"
+
+func TestCutTyped(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"今天 10 t",
+		"天氣 10 n",
+		"上海 10 ns",
+		"江南style 3 n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "english번역『하다』今天天氣，ステabc123 1+1=2上海*important*"
+	want := []TypedToken{
+		{"english", Alnum, false},
+		{"번", Other, false},
+		{"역", Other, false},
+		{"『", Punct, false},
+		{"하", Other, false},
+		{"다", Other, false},
+		{"』", Punct, false},
+		{"今天", Han, false},
+		{"天氣", Han, false},
+		{"，", Punct, false},
+		{"ス", Other, false},
+		{"テ", Other, false},
+		{"abc123", Alnum, false},
+		{"1", Alnum, false},
+		{"+", Other, false},
+		{"1", Alnum, false},
+		{"=", Other, false},
+		{"2", Alnum, false},
+		{"上海", Han, false},
+		{"*", Punct, false},
+		{"important", Alnum, false},
+		{"*", Punct, false},
+	}
+	assertDeepEqual(t, want, tk.CutTyped(text, false))
+
+	tk.KeepNumbers = true
+	tk.MixedWords = true
+	want = []TypedToken{{"3.5", Alnum, false}, {"江南style", Han, false}}
+	assertDeepEqual(t, want, tk.CutTyped("3.5 江南style", false))
+	assertEqual(t, Space, tokenKind(" \n"))
+	assertEqual(t, "Punct", Punct.String())
+}
+
+func TestMarkUnknownHan(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"撙節 3 v",
+		"好 90 a",
+		"很 80 d",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 撙 is only a prefix of 撙節.
+	text := "很好撙"
+	want := []TypedToken{{"很", Han, false}, {"好", Han, false}, {"撙", Han, false}}
+	assertDeepEqual(t, want, tk.CutTyped(text, false))
+
+	tk.MarkUnknownHan = true
+	want = []TypedToken{{"很", Han, false}, {"好", Han, false}, {"撙", HanUnknown, false}}
+	assertDeepEqual(t, want, tk.CutTyped(text, false))
+	assertEqual(t, "HanUnknown", HanUnknown.String())
+}
+
+func TestDetectScript(t *testing.T) {
+	cases := []struct {
+		text string
+		want ScriptStats
+	}{
+		{"some english words", ScriptStats{0, 1, 0}},
+		{"今天天氣很好", ScriptStats{1, 0, 0}},
+		// 4 Han, 4 Latin and 2 others; spaces don't count.
+		{"今天 café 很好，ス", ScriptStats{0.4, 0.4, 0.2}},
+		{" \n", ScriptStats{}},
+	}
+	for _, c := range cases {
+		assertEqual(t, c.want, DetectScript(c.text))
+	}
+}
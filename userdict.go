@@ -0,0 +1,175 @@
+package tokenizer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Multiply the frequency of each word loaded by LoadUserDict by
+// weight, so that domain words win over the base dictionary's
+// segmentations without working out frequencies by hand. A
+// weighted frequency is at least 1. 0 means no weight.
+func WithUserDictWeight(weight float64) Option {
+	return func(tk *Tokenizer) {
+		tk.userDictWeight = weight
+	}
+}
+
+type userWord struct {
+	word string
+	freq int
+	pos  string
+}
+
+// Add the words in a user dictionary file to the prefix
+// dictionary. Each line is "word [freq] [pos]", like jieba's user
+// dictionaries. Words without a frequency get one from
+// suggestFreq, and words with a POS are tagged as by AddWordPOS.
+// Frequencies are weighted by WithUserDictWeight. Existing words
+// are replaced.
+func (tk *Tokenizer) LoadUserDict(filename string) error {
+	words, err := readUserDict(filename)
+	if err != nil {
+		return err
+	}
+	// Suggest frequencies first: suggestFreq cuts text, which
+	// takes the lock.
+	for i := range words {
+		if words[i].freq < 1 {
+			words[i].freq = tk.pd.suggestFreq(words[i].word, tk)
+		}
+		if tk.userDictWeight > 0 {
+			words[i].freq = int(float64(words[i].freq) * tk.userDictWeight)
+			if words[i].freq < 1 {
+				words[i].freq = 1
+			}
+		}
+	}
+
+	tk.updateDict(func() bool {
+		for _, w := range words {
+			tk.pd.insertTerm(w.word, w.freq)
+			if w.pos != "" {
+				if tk.pd.pos == nil {
+					tk.pd.pos = map[string]string{}
+				}
+				tk.pd.pos[w.word] = w.pos
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// Read the lines of a user dictionary file. A frequency of 0
+// means none was given.
+func readUserDict(filename string) ([]userWord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := []userWord{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: want \"word [freq] [pos]\", got %q", filename, lineNo, line)
+		}
+		w := userWord{word: fields[0]}
+		rest := fields[1:]
+		if len(rest) != 0 {
+			if freq, err := strconv.Atoi(rest[0]); err == nil {
+				if freq < 0 {
+					return nil, fmt.Errorf("%s:%d: invalid frequency %q", filename, lineNo, rest[0])
+				}
+				w.freq = freq
+				rest = rest[1:]
+			}
+		}
+		if len(rest) > 1 {
+			return nil, fmt.Errorf("%s:%d: invalid frequency %q", filename, lineNo, fields[1])
+		}
+		if len(rest) == 1 {
+			w.pos = rest[0]
+		}
+		words = append(words, w)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// MergePolicy decides the frequency of a word that
+// LoadDictionaries finds in more than one dictionary.
+type MergePolicy int
+
+const (
+	// Keep the frequency the word had first: in the tokenizer's
+	// dictionary, or else in the earliest file.
+	MergeKeep MergePolicy = iota
+	// Take the frequency from the latest file.
+	MergeReplace
+	// Add the frequencies up.
+	MergeSum
+)
+
+// Load dictionary files, in the format of NewTokenizer, and merge
+// them into the prefix dictionary. The files are read in
+// parallel, but merged in the order of `paths` under one write
+// lock, so the result doesn't depend on which file loads first.
+// If any file fails to load, the dictionary is left unchanged.
+func (tk *Tokenizer) LoadDictionaries(paths []string, policy MergePolicy) error {
+	loaded := make([]*prefixDictionary, len(paths))
+	errs := make([]error, len(paths))
+	wg := sync.WaitGroup{}
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			loaded[i], errs[i] = loadPrefixDictionaryFile(path)
+		}(i, path)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err == nil {
+			err = tk.checkFreqOverflow(loaded[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	tk.updateDict(func() bool {
+		tk.pd.ownTermFreq()
+		for _, pd := range loaded {
+			for term, freq := range pd.termFreq {
+				old, found := tk.pd.termFreq[term]
+				switch {
+				case !found || old == 0:
+				case freq == 0 || policy == MergeKeep:
+					continue
+				case policy == MergeSum:
+					freq += old
+				}
+				tk.pd.termFreq[term] = freq
+				tk.pd.size = addSize(addSize(tk.pd.size, -old), freq)
+			}
+		}
+		return true
+	})
+	return nil
+}
@@ -0,0 +1,130 @@
+package tokenizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeUserDict(t *testing.T, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "user.txt")
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadUserDict(t *testing.T) {
+	tk := Tokenizer{}
+	err := tk.buildPrefixDictionary([]string{
+		"创新 50 v",
+		"办 20 v",
+		"云 10 n",
+		"计算 30 v",
+		"好 90 a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := writeUserDict(t, "\ufeff创新办 3 i\n云计算 500\n\n好 100\n")
+	if err := tk.LoadUserDict(filename); err != nil {
+		t.Fatal(err)
+	}
+	want := []Token{
+		{Word: "云计算", Start: 0, End: 3},
+		{Word: "好", Start: 3, End: 4},
+	}
+	assertDeepEqual(t, want, tk.Tokenize("云计算好", false))
+	assertEqual(t, 3, tk.pd.termFreq["创新办"])
+	assertEqual(t, "i", tk.wordPOS("创新办"))
+	// 好 is replaced, not added again.
+	assertEqual(t, int64(50+20+10+30+100+3+500), tk.pd.size)
+
+	_, err = readUserDict(writeUserDict(t, "a 1 n x\n"))
+	if err == nil {
+		t.Error("want an error for too many fields")
+	}
+	_, err = readUserDict(writeUserDict(t, "a n x\n"))
+	if err == nil {
+		t.Error("want an error for a missing frequency")
+	}
+	words, err := readUserDict(writeUserDict(t, "a\nb nz\nc 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []userWord{{"a", 0, ""}, {"b", 0, "nz"}, {"c", 5, ""}}, words)
+}
+
+func TestUserDictWeight(t *testing.T) {
+	base := []string{
+		"北京 500 ns",
+		"大学 400 n",
+		"好 90 a",
+	}
+	filename := writeUserDict(t, "北京大学 2 nt\n")
+	text := "北京大学好"
+
+	tk := Tokenizer{}
+	if err := tk.buildPrefixDictionary(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := tk.LoadUserDict(filename); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []string{"北京", "大学", "好"}, tk.Cut(text, false))
+
+	tk = Tokenizer{}
+	WithUserDictWeight(1000)(&tk)
+	if err := tk.buildPrefixDictionary(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := tk.LoadUserDict(filename); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEqual(t, []string{"北京大学", "好"}, tk.Cut(text, false))
+	assertEqual(t, 2000, tk.pd.termFreq["北京大学"])
+}
+
+func TestLoadDictionaries(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.txt")
+	second := filepath.Join(dir, "second.txt")
+	os.WriteFile(first, []byte("雲端 20 n\n計算 30 v\n好 5 a\n"), 0o644)
+	os.WriteFile(second, []byte("雲端計算 40 n\n計算 10 v\n"), 0o644)
+
+	// Frequencies of 計算, in both files, and 好, in the
+	// tokenizer and the first file.
+	tests := []struct {
+		policy MergePolicy
+		freq   int
+		good   int
+	}{
+		{MergeKeep, 30, 90},
+		{MergeReplace, 10, 5},
+		{MergeSum, 40, 95},
+	}
+	for _, test := range tests {
+		tk := Tokenizer{}
+		if err := tk.buildPrefixDictionary([]string{"好 90 a"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tk.LoadDictionaries([]string{first, second}, test.policy); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, test.freq, tk.pd.termFreq["計算"])
+		assertEqual(t, test.good, tk.pd.termFreq["好"])
+		assertEqual(t, int64(20+test.freq+40+test.good), tk.pd.size)
+		assertDeepEqual(t, []string{"雲端計算", "好"}, tk.Cut("雲端計算好", false))
+	}
+
+	tk := Tokenizer{}
+	if err := tk.buildPrefixDictionary([]string{"好 90 a"}); err != nil {
+		t.Fatal(err)
+	}
+	err := tk.LoadDictionaries([]string{first, filepath.Join(dir, "missing.txt")}, MergeKeep)
+	if err == nil {
+		t.Fatal("want an error for a missing file")
+	}
+	assertDeepEqual(t, DictStats{Words: 1, Size: 90}, tk.Stats())
+}